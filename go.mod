@@ -4,9 +4,13 @@ go 1.21.5
 
 require (
 	github.com/djimenez/iconv-go v0.0.0-20160305225143-8960e66bd3da
+	github.com/dsnet/compress v0.0.1
+	github.com/klauspost/compress v1.17.11
 	github.com/mattn/go-gtk v0.0.0-20240119050609-48574e312fac
 	github.com/naoina/toml v0.1.1
 	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.14.0
 )
 
 require (
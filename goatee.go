@@ -2,10 +2,9 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"math"
-	"net/url"
 	"os"
-	"os/user"
 	"strings"
 
 	"github.com/mattn/go-gtk/gdk"
@@ -18,17 +17,19 @@ var (
 	ui   *UI
 	conf *Conf
 
-	gvfsPath = "/run/user/%s/gvfs/"
-
 	newtabiter int
 
 	langManager = gsv.SourceLanguageManagerGetDefault()
 	languages   = langManager.GetLanguageIds()
 
-	charsets = []string{
+	defaultFavouriteCharsets = []string{
 		CHARSET_UTF8,
 		"utf-16",
-		"",
+		CHARSET_UTF16LE,
+		CHARSET_UTF16BE,
+		CHARSET_UTF32LE,
+		CHARSET_UTF32BE,
+		charsetSeparator,
 		"ISO-8859-2",
 		"ISO-8859-7",
 		"ISO-8859-9",
@@ -39,7 +40,7 @@ var (
 		"Big5",
 		"TIS-620",
 		"KOI8-R",
-		"",
+		charsetSeparator,
 		"windows-874",
 		"windows-1250",
 		"windows-1251",
@@ -49,36 +50,84 @@ var (
 		"windows-1255",
 		"windows-1256",
 		"windows-1257",
-		"windows-1258",
-		"",
-		CHARSET_BINARY}
+		"windows-1258"}
+
+	lineEndings = []string{
+		LINE_ENDING_LF,
+		LINE_ENDING_CRLF,
+		LINE_ENDING_CR}
 )
 
 func init() {
 	// log.SetFlags(log.Lshortfile)
 
-	user, _ := user.Current()
-	gvfsPath = fmt.Sprintf(gvfsPath, user.Uid)
-
 	conf = NewConf()
 }
 
 func main() {
 	gtk.Init(nil)
 	ui = CreateUI()
+	ui.recent = LoadRecent()
+	ui.metadata = LoadMetadata()
+	ui.recent.UpdateMenu()
 
-	switch {
-	case len(os.Args) == 1:
-		ui.NewTab("")
-	case os.Args[1] == "--help" || os.Args[1] == "-h":
-		fmt.Println("Usage:\n\tgoatee [files...]")
-		os.Exit(0)
-	default:
-		for i := 1; i < len(os.Args); i++ {
-			ui.NewTab(os.Args[i])
+	var session = defaultSession
+	var readonly bool
+	var stdin bool
+	var files []string
+	for i := 1; i < len(os.Args); i++ {
+		switch os.Args[i] {
+		case "--help", "-h":
+			fmt.Println("Usage:\n\tgoatee [--session NAME] [--readonly] [files...]\n\tcommand | goatee -")
+			os.Exit(0)
+		case "-":
+			stdin = true
+		case "--readonly":
+			readonly = true
+		case "--session":
+			if i+1 < len(os.Args) {
+				i++
+				session = os.Args[i]
+			}
+		default:
+			files = append(files, os.Args[i])
+		}
+	}
+
+	if err := validSessionName(session); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	//the session used by another instance is not restored, it would be overwritten on quit
+	ui.session = session
+	ui.lockSession(defaultSession)
+	if ui.lockSession(session) {
+		if err := ui.LoadSession(session); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	} else {
+		log.Printf("session `%s` is used by another instance", session)
+	}
+
+	for _, filename := range files {
+		t := ui.NewTab(filename)
+		if t != nil && readonly {
+			t.SetReadOnly(true)
+			t.UpdateMenuSeleted()
 		}
 	}
 
+	if stdin {
+		ui.OpenStdin()
+	}
+
+	ui.RecoverSwaps()
+
+	if len(ui.tabs) == 0 {
+		ui.NewTab("")
+	}
+
 	gtk.Main()
 }
 
@@ -125,15 +174,64 @@ func structureLanguages() map[string][]language {
 	return structure
 }
 
+// charsetSeparator separates groups in the list of favourite charsets
+const charsetSeparator = "-"
+
+// charsets returns favourite charsets for the quick menu and binary, empty string is a separator
+func charsets() []string {
+	var res []string
+	used := map[string]bool{CHARSET_BINARY: true}
+	for _, c := range conf.Encodings.Favourites {
+		if c == charsetSeparator {
+			if len(res) > 0 && len(res[len(res)-1]) > 0 {
+				res = append(res, "")
+			}
+			continue
+		}
+		if len(c) == 0 || used[c] {
+			continue
+		}
+		used[c] = true
+		res = append(res, c)
+	}
+	if len(res) > 0 && len(res[len(res)-1]) > 0 {
+		res = append(res, "")
+	}
+	return append(res, CHARSET_BINARY)
+}
+
+// xmlEncodings quick menu of encodings, charsets chosen in the dialog are added to the placeholder
 func xmlEncodings() string {
 	var xmldata []string
-	for _, c := range charsets {
+	for _, c := range charsets() {
 		if len(c) == 0 {
 			xmldata = append(xmldata, "<separator />")
 		} else {
 			xmldata = append(xmldata, "<menuitem action='"+c+"' />")
 		}
 	}
+	xmldata = append(xmldata, "<separator />", "<placeholder name='OtherEncodings' />", "<menuitem action='ChooseEncoding' />")
+	return strings.Join(xmldata, "\n")
+}
+
+func xmlReopenEncodings() string {
+	var xmldata []string
+	for _, c := range charsets() {
+		if len(c) == 0 {
+			xmldata = append(xmldata, "<separator />")
+		} else {
+			xmldata = append(xmldata, "<menuitem action='reopen-"+c+"' />")
+		}
+	}
+	xmldata = append(xmldata, "<separator />", "<menuitem action='ReopenOther' />")
+	return strings.Join(xmldata, "\n")
+}
+
+func xmlLineEndings() string {
+	var xmldata []string
+	for _, le := range lineEndings {
+		xmldata = append(xmldata, "<menuitem action='"+le+"' />")
+	}
 	return strings.Join(xmldata, "\n")
 }
 
@@ -152,17 +250,15 @@ func convertColor(col []int) *gdk.Color {
 }
 
 func resolveFilename(filename string) string {
-	u, err := url.Parse(filename)
-	if err != nil {
+	//native locations, ex: 'file://', are opened by path, others, ex: 'sftp://', through GIO
+	if isURI(filename) {
+		if local := gioLocalPath(filename); len(local) > 0 {
+			return local
+		}
 		return filename
 	}
 
-	//if scheme exist, ex: 'sftp://' then parse path with how URI
-	if u.Scheme != "" {
-		filename = gio.NewGFileForURI(filename).GetPath()
-	} else {
-		filename = gio.NewGFileForPath(filename).GetPath()
-	}
+	filename = gio.NewGFileForPath(filename).GetPath()
 
 	return filename
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const CHARSET_UTF16LE = "UTF-16LE"
+const CHARSET_UTF16BE = "UTF-16BE"
+const CHARSET_UTF32LE = "UTF-32LE"
+const CHARSET_UTF32BE = "UTF-32BE"
+
+// boms byte order marks, UTF-32LE must be checked before UTF-16LE
+var boms = []struct {
+	charset string
+	bom     []byte
+}{
+	{CHARSET_UTF32LE, []byte{0xFF, 0xFE, 0x00, 0x00}},
+	{CHARSET_UTF32BE, []byte{0x00, 0x00, 0xFE, 0xFF}},
+	{CHARSET_UTF8, []byte{0xEF, 0xBB, 0xBF}},
+	{CHARSET_UTF16LE, []byte{0xFF, 0xFE}},
+	{CHARSET_UTF16BE, []byte{0xFE, 0xFF}},
+}
+
+// stripBOM returns data without byte order mark and charset defined by it
+func stripBOM(data []byte) ([]byte, string) {
+	for _, b := range boms {
+		if bytes.HasPrefix(data, b.bom) {
+			return data[len(b.bom):], b.charset
+		}
+	}
+	return data, ""
+}
+
+// bomFor returns byte order mark for the charset, nil if charset has not it
+func bomFor(charset string) []byte {
+	for _, b := range boms {
+		if strings.EqualFold(b.charset, charset) {
+			return b.bom
+		}
+	}
+	return nil
+}
+
+// bomEncoding returns charset to decode data which starts with BOM of bomCharset when encoding
+// is chosen, and true if BOM is the mark of the charset. BOM defines byte order of generic
+// UTF-16 and UTF-32, BOM of other charset is a part of the text
+func bomEncoding(encoding, bomCharset string) (string, bool) {
+	switch {
+	case len(bomCharset) == 0:
+		return encoding, false
+	case strings.EqualFold(encoding, bomCharset):
+		return encoding, true
+	case (strings.EqualFold(encoding, "UTF-16") || strings.EqualFold(encoding, "UTF-32")) &&
+		strings.HasPrefix(bomCharset, strings.ToUpper(encoding)):
+		return bomCharset, true
+	}
+	return encoding, false
+}
+
+// detectUTF16 guess UTF-16 and UTF-32 without BOM by zero bytes, text in latin scripts
+// has zero in every second (or three of four) byte, binary data with regular zero bytes
+// is rejected by printability of the decoded text
+func detectUTF16(data []byte) string {
+	charset := guessUTF16(data)
+	if len(charset) == 0 || !printableIn(data, charset) {
+		return ""
+	}
+	return charset
+}
+
+func guessUTF16(data []byte) string {
+	if len(data) > 4096 {
+		data = data[:4096]
+	}
+	if len(data) < 4 {
+		return ""
+	}
+
+	var zeros [4]int
+	for i, b := range data {
+		if b == 0 {
+			zeros[i%4]++
+		}
+	}
+
+	quarter := len(data) / 4
+	half := len(data) / 2
+
+	switch {
+	case zeros[1] > quarter*9/10 && zeros[2] > quarter*9/10 && zeros[3] > quarter*9/10 && zeros[0] < quarter/10:
+		return CHARSET_UTF32LE
+	case zeros[0] > quarter*9/10 && zeros[1] > quarter*9/10 && zeros[2] > quarter*9/10 && zeros[3] < quarter/10:
+		return CHARSET_UTF32BE
+	}
+
+	even := zeros[0] + zeros[2]
+	odd := zeros[1] + zeros[3]
+
+	switch {
+	case odd > half*4/10 && even < half/20:
+		return CHARSET_UTF16LE
+	case even > half*4/10 && odd < half/20:
+		return CHARSET_UTF16BE
+	}
+
+	return ""
+}
+
+// printableIn returns true if the beginning of data decoded from the charset has no
+// undecodable bytes and no control characters except whitespace
+func printableIn(data []byte, charset string) bool {
+	if len(data) > 4096 {
+		data = data[:4096]
+	}
+
+	converter, err := NewConverter(charset, CHARSET_UTF8)
+	if err != nil {
+		return false
+	}
+	defer converter.Close()
+
+	//only the last character may be cut by the end of sample
+	var invalid int
+	converter.Invalid = func(b byte) { invalid++ }
+
+	text, err := converter.ConvertBytes(data)
+	if err != nil || invalid >= utf8.UTFMax {
+		return false
+	}
+
+	for _, r := range string(text) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
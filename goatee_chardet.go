@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/gtk"
+	"github.com/mattn/go-gtk/pango"
+)
+
+// chardetMinimum confidence of chardet result to use it as encoding of the file
+const chardetMinimum = 30
+
+// chardetCertain confidence from which chardet result is not offered for confirmation
+const chardetCertain = 60
+
+// previewLength max number of characters in the preview of encoding candidate
+const previewLength = 120
+
+// OfferEncodingCandidates show infobar with ranked charsets if detection was uncertain,
+// selected charset is previewed on the line with non ascii characters
+func (t *Tab) OfferEncodingCandidates() {
+	t.HideInfoBar("encoding")
+	if len(t.encodingCandidates) == 0 || len(t.Filename) == 0 || t.large != nil {
+		return
+	}
+
+	data, _, err := readFileData(t.Filename)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	data, _ = stripBOM(data)
+	sample := previewSample(data)
+
+	var candidates []string
+	var previews []string
+	combo := gtk.NewComboBoxText()
+	for _, c := range t.encodingCandidates {
+		preview, ok := t.previewEncoding(sample, c.Charset)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, c.Charset)
+		previews = append(previews, preview)
+		combo.AppendText(fmt.Sprintf("%s (%d%%)", c.Charset, c.Confidence))
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	preview := gtk.NewLabel("")
+	preview.SetEllipsize(pango.ELLIPSIZE_END)
+	preview.SetAlignment(0, 0.5)
+
+	combo.Connect("changed", func() {
+		if n := combo.GetActive(); n >= 0 {
+			preview.SetText(previews[n])
+		}
+	})
+	combo.SetActive(0)
+
+	box := gtk.NewHBox(false, 6)
+	box.PackStart(gtk.NewLabel("Encoding is uncertain:"), false, false, 0)
+	box.PackStart(combo, false, false, 0)
+	box.PackStart(preview, true, true, 0)
+
+	t.ShowInfoBarWidget("encoding", gtk.MESSAGE_QUESTION, box, []string{"Apply", "Keep"}, func(n int) {
+		if n != 0 {
+			return
+		}
+
+		charset := candidates[combo.GetActive()]
+		binary := t.Encoding == CHARSET_BINARY
+		t.ChangeCurrEncoding(charset)
+		if binary && t.Encoding != CHARSET_BINARY {
+			t.ChangeLanguage(t.DetectLanguage([]byte(t.GetText(true))))
+		}
+		t.UpdateMenuSeleted()
+		if t.Encoding == charset {
+			ui.metadata.SetEncoding(t.Filename, charset)
+		}
+	})
+}
+
+// previewSample returns the first line with non ascii bytes, charsets differ only on them
+func previewSample(data []byte) []byte {
+	if len(data) > 64<<10 {
+		data = data[:64<<10]
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	sample := lines[0]
+	for _, line := range lines {
+		if bytes.IndexFunc(line, func(r rune) bool { return r >= utf8.RuneSelf }) >= 0 {
+			sample = line
+			break
+		}
+	}
+
+	sample = bytes.TrimSpace(sample)
+	if len(sample) > previewLength*4 {
+		sample = sample[:previewLength*4]
+	}
+	return sample
+}
+
+// previewEncoding decode the sample, the last character may be cut by the end of sample,
+// false if charset is not supported by iconv or sample is not valid in it
+func (t *Tab) previewEncoding(sample []byte, charset string) (string, bool) {
+	for cut := 0; cut < 4 && cut <= len(sample); cut++ {
+		data, err := t.ChangeEncoding(sample[:len(sample)-cut], CHARSET_UTF8, charset)
+		if err != nil {
+			continue
+		}
+
+		preview := string(data)
+		if utf8.RuneCountInString(preview) > previewLength {
+			preview = string([]rune(preview)[:previewLength]) + "…"
+		}
+		return preview, true
+	}
+	return "", false
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mattn/go-gtk/gtk"
+	"github.com/ulikunitz/xz"
+)
+
+const COMPRESS_GZIP = "gzip"
+const COMPRESS_BZIP2 = "bzip2"
+const COMPRESS_XZ = "xz"
+const COMPRESS_ZSTD = "zstd"
+
+var compressions = []struct {
+	format string
+	ext    string
+	magic  []byte
+}{
+	{COMPRESS_GZIP, ".gz", []byte{0x1F, 0x8B}},
+	{COMPRESS_BZIP2, ".bz2", []byte("BZh")},
+	{COMPRESS_XZ, ".xz", []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}},
+	{COMPRESS_ZSTD, ".zst", []byte{0x28, 0xB5, 0x2F, 0xFD}},
+}
+
+// compressLevelUnknown level is not stored in the file, ex: zstd, the default level is used
+const compressLevelUnknown = -1
+
+// xzDictCaps dictionary sizes of xz presets 0-9, the preset is not stored in the file, but
+// dictionary size is
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// detectCompression returns format of compressed data by magic bytes and level
+// if it is stored in the header, otherwise compressLevelUnknown
+func detectCompression(data []byte) (string, int) {
+	for _, c := range compressions {
+		if !bytes.HasPrefix(data, c.magic) {
+			continue
+		}
+
+		switch c.format {
+		case COMPRESS_GZIP:
+			//XFL byte: 2 - the slowest algorithm, 4 - the fastest
+			if len(data) > 8 && data[8] == 2 {
+				return c.format, gzip.BestCompression
+			}
+			if len(data) > 8 && data[8] == 4 {
+				return c.format, gzip.BestSpeed
+			}
+		case COMPRESS_BZIP2:
+			//block size 100k-900k is the level
+			if len(data) > 3 && data[3] >= '1' && data[3] <= '9' {
+				return c.format, int(data[3] - '0')
+			}
+			return "", 0
+		case COMPRESS_XZ:
+			if dict := xzDictSize(data); dict > 0 {
+				for level, dictCap := range xzDictCaps {
+					if dict <= dictCap {
+						return c.format, level
+					}
+				}
+				return c.format, len(xzDictCaps) - 1
+			}
+		}
+		return c.format, compressLevelUnknown
+	}
+	return "", 0
+}
+
+// xzDictSize returns dictionary size of LZMA2 filter from the header of the first block,
+// 0 if it is not found
+func xzDictSize(data []byte) int {
+	const streamHeader = 12
+	if len(data) < streamHeader+2 || data[streamHeader] == 0 {
+		return 0
+	}
+	end := streamHeader + (int(data[streamHeader])+1)*4
+	if end > len(data) {
+		return 0
+	}
+
+	flags := data[streamHeader+1]
+	i := streamHeader + 2
+	varint := func() (int, bool) {
+		var v int
+		for n := 0; i < end && n < 9; n++ {
+			b := data[i]
+			i++
+			v |= int(b&0x7F) << (7 * n)
+			if b&0x80 == 0 {
+				return v, true
+			}
+		}
+		return 0, false
+	}
+
+	//compressed and uncompressed sizes are optional
+	for _, bit := range []byte{0x40, 0x80} {
+		if flags&bit != 0 {
+			if _, ok := varint(); !ok {
+				return 0
+			}
+		}
+	}
+
+	for n := 0; n <= int(flags&3); n++ {
+		id, ok := varint()
+		if !ok {
+			return 0
+		}
+		size, ok := varint()
+		if !ok || i+size > end {
+			return 0
+		}
+
+		//LZMA2 filter has one byte of properties with encoded dictionary size
+		if id == 0x21 && size == 1 {
+			b := data[i]
+			if b > 40 {
+				return 0
+			}
+			if b == 40 {
+				return xzDictCaps[len(xzDictCaps)-1] + 1
+			}
+			return (2 | int(b&1)) << (b/2 + 11)
+		}
+		i += size
+	}
+	return 0
+}
+
+// isCompressedFile check magic bytes at the beginning of the file
+func isCompressedFile(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(f, head)
+	format, _ := detectCompression(head[:n])
+	return len(format) > 0
+}
+
+// trimCompressionExt remove extension of compressed file, ex: `config.json.gz` -> `config.json`
+func trimCompressionExt(filename string) string {
+	for _, c := range compressions {
+		if strings.HasSuffix(filename, c.ext) {
+			return strings.TrimSuffix(filename, c.ext)
+		}
+	}
+	return filename
+}
+
+// decompressData returns decompressed data, format and level of compression. If data starts
+// by magic bytes, but fails to decompress, ex: truncated archive or plain file which starts
+// by the same bytes, it is returned as is with the error as warning
+func decompressData(data []byte) ([]byte, string, int, error) {
+	format, level := detectCompression(data)
+	if len(format) == 0 {
+		return data, "", 0, nil
+	}
+
+	res, err := decompress(data, format)
+	if err != nil {
+		return data, "", 0, err
+	}
+	return res, format, level, nil
+}
+
+func decompress(data []byte, format string) ([]byte, error) {
+	var r io.Reader
+	var err error
+
+	switch format {
+	case COMPRESS_GZIP:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case COMPRESS_BZIP2:
+		r, err = bzip2.NewReader(bytes.NewReader(data), nil)
+	case COMPRESS_XZ:
+		r, err = xz.NewReader(bytes.NewReader(data))
+	case COMPRESS_ZSTD:
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(bytes.NewReader(data))
+		if err == nil {
+			defer zr.Close()
+			r = zr
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression `%s`", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed decompress %s, %s", format, err)
+	}
+
+	res, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed decompress %s, %s", format, err)
+	}
+	return res, nil
+}
+
+func compress(data []byte, format string, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch format {
+	case COMPRESS_GZIP:
+		if level == compressLevelUnknown {
+			level = gzip.DefaultCompression
+		}
+		w, err = gzip.NewWriterLevel(&buf, level)
+	case COMPRESS_BZIP2:
+		if level == compressLevelUnknown {
+			level = bzip2.DefaultCompression
+		}
+		w, err = bzip2.NewWriter(&buf, &bzip2.WriterConfig{Level: level})
+	case COMPRESS_XZ:
+		var config xz.WriterConfig
+		if level >= 0 && level < len(xzDictCaps) {
+			config.DictCap = xzDictCaps[level]
+		}
+		w, err = config.NewWriter(&buf)
+	case COMPRESS_ZSTD:
+		w, err = zstd.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unknown compression `%s`", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed compress %s, %s", format, err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed compress %s, %s", format, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed compress %s, %s", format, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// warnCompressionLevel tell once that the file is saved with the default level, because the
+// level used before is not stored in the file
+func (t *Tab) warnCompressionLevel() {
+	if t.compressLevel != compressLevelUnknown || t.compressWarned {
+		return
+	}
+	t.compressWarned = true
+
+	message := fmt.Sprintf("Compression level of %s is not stored in the file, it is saved with the default level.", t.Compression)
+	t.ShowInfoBar("compresslevel", gtk.MESSAGE_INFO, message, []string{"OK"}, nil)
+}
@@ -20,7 +20,7 @@ import (
 	"github.com/naoina/toml"
 )
 
-//Conf structure contains configuration
+// Conf structure contains configuration
 type Conf struct {
 	window        *gtk.Window                   `toml:",omitempty"`
 	filename      string                        `toml:",omitempty"`
@@ -53,9 +53,28 @@ type Conf struct {
 	Hex struct {
 		BytesInLine int `toml:"bytes-in-line" wgt:"int"`
 	}
+	Save struct {
+		Backup          bool   `toml:"backup" wgt:"checkbox"`
+		BackupTimestamp bool   `toml:"backup-timestamp" wgt:"checkbox"`
+		BackupSuffix    string `toml:"backup-suffix" wgt:"string"`
+	}
+	Swap struct {
+		Enabled  bool `toml:"enabled" wgt:"checkbox"`
+		Interval int  `toml:"interval" wgt:"int"`
+	}
+	LargeFile struct {
+		Threshold int `toml:"threshold" wgt:"int"`
+		PageSize  int `toml:"page-size" wgt:"int"`
+	}
+	Recent struct {
+		MaxItems int `toml:"max-items" wgt:"int"`
+	}
+	Encodings struct {
+		Favourites []string `toml:"favourites" wgt:"strings"`
+	}
 }
 
-//NewConf set default values for configuration and parse config file
+// NewConf set default values for configuration and parse config file
 func NewConf() *Conf {
 	confdir := os.Getenv("XDG_CONFIG_HOME")
 	if confdir == "" {
@@ -94,6 +113,20 @@ func NewConf() *Conf {
 
 	c.Hex.BytesInLine = 16
 
+	c.Save.Backup = false
+	c.Save.BackupTimestamp = false
+	c.Save.BackupSuffix = "~"
+
+	c.Swap.Enabled = true
+	c.Swap.Interval = 10
+
+	c.LargeFile.Threshold = 64
+	c.LargeFile.PageSize = 1024
+
+	c.Recent.MaxItems = 10
+
+	c.Encodings.Favourites = append([]string{}, defaultFavouriteCharsets...)
+
 	//parse config files
 	for _, filename := range configfiles {
 		if err := c.readConfigFile(filename); err == nil {
@@ -140,7 +173,7 @@ func (c *Conf) Write() {
 	}
 }
 
-//OpenWindow open window configuration
+// OpenWindow open window configuration
 func (c *Conf) OpenWindow() {
 	if c.window == nil {
 		c.CreateWindow()
@@ -148,7 +181,7 @@ func (c *Conf) OpenWindow() {
 	c.window.ShowAll()
 }
 
-//CreateWindow create window configuration
+// CreateWindow create window configuration
 func (c *Conf) CreateWindow() {
 	c.window = gtk.NewWindow(gtk.WINDOW_TOPLEVEL)
 	c.window.SetName("Preferences")
@@ -222,6 +255,12 @@ func (c *Conf) newWidget(v reflect.Value, f reflect.StructField) (*gtk.Label, gt
 		w.entry.SetText(v.String())
 		w.entry.Connect("changed", w.UpdateValue)
 
+	case "strings":
+		w.strlist = gtk.NewEntry()
+		w.strlist.SetSizeRequest(150, -1)
+		w.strlist.SetText(strings.Join(v.Interface().([]string), ", "))
+		w.strlist.Connect("changed", w.UpdateValue)
+
 	case "int":
 		w.spnbtn = gtk.NewSpinButtonWithRange(-1, 2048, 1)
 		w.spnbtn.SetSizeRequest(150, -1)
@@ -277,12 +316,13 @@ type ConfWidget struct {
 
 	conf *Conf
 
-	chkbtn *gtk.CheckButton
-	entry  *gtk.Entry
-	spnbtn *gtk.SpinButton
-	colbtn *gtk.ColorButton
-	fntbtn *gtk.FontButton
-	cmbbox *gtk.ComboBoxText
+	chkbtn  *gtk.CheckButton
+	entry   *gtk.Entry
+	strlist *gtk.Entry
+	spnbtn  *gtk.SpinButton
+	colbtn  *gtk.ColorButton
+	fntbtn  *gtk.FontButton
+	cmbbox  *gtk.ComboBoxText
 }
 
 func (w *ConfWidget) UpdateValue() {
@@ -291,6 +331,14 @@ func (w *ConfWidget) UpdateValue() {
 		w.Field.SetBool(w.chkbtn.GetActive())
 	case w.entry != nil:
 		w.Field.SetString(w.entry.GetText())
+	case w.strlist != nil:
+		var list []string
+		for _, s := range strings.Split(w.strlist.GetText(), ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				list = append(list, s)
+			}
+		}
+		w.Field.Set(reflect.ValueOf(list))
 	case w.spnbtn != nil:
 		n, _ := strconv.Atoi(w.spnbtn.Entry.GetText())
 		w.Field.SetInt(int64(n))
@@ -316,6 +364,8 @@ func (w *ConfWidget) GetWidget() gtk.IWidget {
 		return w.chkbtn
 	case w.entry != nil:
 		return w.entry
+	case w.strlist != nil:
+		return w.strlist
 	case w.spnbtn != nil:
 		return w.spnbtn
 	case w.colbtn != nil:
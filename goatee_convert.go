@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"syscall"
+
+	iconv "github.com/djimenez/iconv-go"
+)
+
+// convertChunk size of input and output buffers of the converter
+const convertChunk = 32 << 10
+
+// ConvertError conversion failed at the byte offset of input
+type ConvertError struct {
+	From   string
+	To     string
+	Offset int64
+	Err    error
+}
+
+func (e *ConvertError) Error() string {
+	return fmt.Sprintf("failed change encoding from `%s` to `%s` at byte %d, %s", e.From, e.To, e.Offset, e.Err)
+}
+
+// Converter streaming conversion between charsets by iconv, buffers are reused by following
+// conversions, so memory does not depend on size of the data. Stream may be converted by parts,
+// BOM of UTF-16/32 and shift state of stateful charsets are kept between them
+type Converter struct {
+	from string
+	to   string
+	cd   *iconv.Converter
+
+	in  []byte
+	out []byte
+
+	//offset of the input in the current stream
+	offset int64
+
+	// Invalid if set is called for every byte not valid in the source charset, the byte is
+	// skipped instead of failing the conversion
+	Invalid func(b byte)
+}
+
+func NewConverter(from, to string) (*Converter, error) {
+	cd, err := iconv.NewConverter(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("unknown charsets: `%s` `%s`, %s", to, from, err)
+	}
+
+	return &Converter{
+		from: from,
+		to:   to,
+		cd:   cd,
+		in:   make([]byte, convertChunk),
+		out:  make([]byte, convertChunk),
+	}, nil
+}
+
+func (c *Converter) Close() {
+	c.cd.Close()
+}
+
+// Convert read r to the end and write converted data to w as one whole stream
+func (c *Converter) Convert(w io.Writer, r io.Reader) error {
+	c.Reset()
+	if err := c.ConvertPart(w, r); err != nil {
+		return err
+	}
+	return c.Flush(w)
+}
+
+// Reset start new stream, BOM of UTF-16/32 is written by the next conversion
+func (c *Converter) Reset() {
+	c.cd.Convert(nil, nil)
+	c.offset = 0
+}
+
+// ConvertPart read r to the end and write converted data to w as the next part of the stream
+func (c *Converter) ConvertPart(w io.Writer, r io.Reader) error {
+	var pending []byte
+	var eof bool
+	for {
+		//character may be cut by the end of chunk, the rest of it is read before converting
+		if !eof && len(pending) < len(c.in)/2 {
+			n := copy(c.in, pending)
+			m, err := r.Read(c.in[n:])
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+			pending = c.in[:n+m]
+		}
+
+		if len(pending) == 0 {
+			if eof {
+				return nil
+			}
+			continue
+		}
+
+		read, written, err := c.cd.Convert(pending, c.out)
+		if written > 0 {
+			if _, err := w.Write(c.out[:written]); err != nil {
+				return err
+			}
+		}
+		pending = pending[read:]
+		c.offset += int64(read)
+
+		switch {
+		case err == nil || err == syscall.E2BIG:
+		case err == syscall.EINVAL && !eof:
+		case (err == syscall.EILSEQ || err == syscall.EINVAL) && c.Invalid != nil:
+			c.Invalid(pending[0])
+			pending = pending[1:]
+			c.offset++
+		default:
+			return &ConvertError{From: c.from, To: c.to, Offset: c.offset, Err: err}
+		}
+	}
+}
+
+// Flush end the stream by shift sequence which returns stateful charset to the initial state,
+// ex: ISO-2022-JP. Iconv starts new stream after it and writes BOM again, so raw bytes are
+// written between parts of the stream without flushing
+func (c *Converter) Flush(w io.Writer) error {
+	_, written, err := c.cd.Convert(nil, c.out)
+	if err != nil {
+		return &ConvertError{From: c.from, To: c.to, Offset: c.offset, Err: err}
+	}
+	if written == 0 {
+		return nil
+	}
+	_, err = w.Write(c.out[:written])
+	return err
+}
+
+// ConvertBytes returns converted data
+func (c *Converter) ConvertBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := c.Convert(&buf, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+)
+
+var convertTests = []struct {
+	charset string
+	text    string
+	data    []byte
+}{
+	{"CP1251", "Привет, мир", []byte{0xCF, 0xF0, 0xE8, 0xE2, 0xE5, 0xF2, ',', ' ', 0xEC, 0xE8, 0xF0}},
+	{"ISO-2022-JP", "a日本b", []byte{'a', 0x1B, '$', 'B', 0x46, 0x7C, 0x4B, 0x5C, 0x1B, '(', 'B', 'b'}},
+	{"UTF-16LE", "aб𝄞", []byte{'a', 0x00, 0x31, 0x04, 0x34, 0xD8, 0x1E, 0xDD}},
+	{"SHIFT_JIS", "a日本ｱ", []byte{'a', 0x93, 0xFA, 0x96, 0x7B, 0xB1}},
+}
+
+func TestConvertBytes(t *testing.T) {
+	for _, tt := range convertTests {
+		t.Run(tt.charset, func(t *testing.T) {
+			encoder, err := NewConverter(CHARSET_UTF8, tt.charset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer encoder.Close()
+
+			data, err := encoder.ConvertBytes([]byte(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, tt.data) {
+				t.Errorf("encoded % X, want % X", data, tt.data)
+			}
+
+			decoder, err := NewConverter(tt.charset, CHARSET_UTF8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+
+			text, err := decoder.ConvertBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(text) != tt.text {
+				t.Errorf("decoded %q, want %q", text, tt.text)
+			}
+		})
+	}
+}
+
+// TestConvertChunkBoundary input is longer than the buffer of converter, text is shifted, so
+// every byte of the sample, including the middle of multibyte characters, falls on the boundary
+func TestConvertChunkBoundary(t *testing.T) {
+	for _, tt := range convertTests {
+		t.Run(tt.charset, func(t *testing.T) {
+			encoder, err := NewConverter(CHARSET_UTF8, tt.charset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer encoder.Close()
+
+			decoder, err := NewConverter(tt.charset, CHARSET_UTF8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+
+			x, err := encoder.ConvertBytes([]byte("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := convertChunk*2/len(tt.data) + 1
+			for shift := 0; shift < len(tt.text)+len(tt.data); shift++ {
+				text := []byte(strings.Repeat("x", shift) + strings.Repeat(tt.text, n))
+				data := append(bytes.Repeat(x, shift), bytes.Repeat(tt.data, n)...)
+
+				encoded, err := encoder.ConvertBytes(text)
+				if err != nil {
+					t.Fatalf("shift %d: %s", shift, err)
+				}
+				if !bytes.Equal(encoded, data) {
+					t.Fatalf("shift %d: encoded %d bytes differ from expected %d bytes", shift, len(encoded), len(data))
+				}
+
+				decoded, err := decoder.ConvertBytes(data)
+				if err != nil {
+					t.Fatalf("shift %d: %s", shift, err)
+				}
+				if !bytes.Equal(decoded, text) {
+					t.Fatalf("shift %d: decoded %d bytes differ from expected %d bytes", shift, len(decoded), len(text))
+				}
+			}
+		})
+	}
+}
+
+// TestConvertShortReads reader returns less than requested, so characters are cut by reads
+func TestConvertShortReads(t *testing.T) {
+	for _, tt := range convertTests {
+		t.Run(tt.charset, func(t *testing.T) {
+			decoder, err := NewConverter(tt.charset, CHARSET_UTF8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+
+			data := bytes.Repeat(tt.data, 100)
+			var decoded bytes.Buffer
+			if err := decoder.Convert(&decoded, iotest.OneByteReader(bytes.NewReader(data))); err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.Repeat(tt.text, 100); decoded.String() != want {
+				t.Errorf("decoded %q, want %q", decoded.String(), want)
+			}
+		})
+	}
+}
+
+func TestConvertErrorOffset(t *testing.T) {
+	tests := []struct {
+		from   string
+		to     string
+		data   string
+		offset int64
+	}{
+		{CHARSET_UTF8, "CP1251", "abc\xffdef", 3},
+		{CHARSET_UTF8, "CP1251", "абв日", 6},
+		{"SHIFT_JIS", CHARSET_UTF8, "ab\x93\xFA\x85\x40", 4},
+		{CHARSET_UTF8, "ISO-2022-JP", strings.Repeat("日", convertChunk) + "\xff", convertChunk * 3},
+	}
+
+	for _, tt := range tests {
+		converter, err := NewConverter(tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = converter.ConvertBytes([]byte(tt.data))
+		converter.Close()
+
+		var cerr *ConvertError
+		if !errors.As(err, &cerr) {
+			t.Errorf("%s to %s: error %v, want ConvertError", tt.from, tt.to, err)
+			continue
+		}
+		if cerr.Err != syscall.EILSEQ {
+			t.Errorf("%s to %s: error %v, want EILSEQ", tt.from, tt.to, cerr.Err)
+		}
+		if cerr.Offset != tt.offset {
+			t.Errorf("%s to %s: offset %d, want %d", tt.from, tt.to, cerr.Offset, tt.offset)
+		}
+	}
+}
+
+// eventWriter records converted text and invalid bytes in the order they are reported
+type eventWriter struct {
+	events []string
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.events = append(w.events, string(p))
+	return len(p), nil
+}
+
+func TestConvertInvalid(t *testing.T) {
+	converter, err := NewConverter("CP1251", CHARSET_UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer converter.Close()
+
+	//0x98 is not defined in CP1251
+	w := new(eventWriter)
+	converter.Invalid = func(b byte) {
+		w.events = append(w.events, fmt.Sprintf("%02X", b))
+	}
+	if err := converter.Convert(w, bytes.NewReader([]byte("a\x98\x98b\xCF\x98"))); err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(w.events, "|")
+	want := "a|98|98|bП|98"
+	if got != want {
+		t.Errorf("events %s, want %s", got, want)
+	}
+}
+
+func TestConvertStream(t *testing.T) {
+	//shift sequence is written at the end of every whole conversion
+	converter, err := NewConverter(CHARSET_UTF8, "ISO-2022-JP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x1B, '$', 'B', 0x46, 0x7C, 0x1B, '(', 'B'}
+	for i := 0; i < 2; i++ {
+		data, err := converter.ConvertBytes([]byte("日"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Errorf("conversion %d: % X, want % X", i, data, want)
+		}
+	}
+	converter.Close()
+
+	//parts of one stream have one BOM and raw bytes between them
+	converter, err = NewConverter(CHARSET_UTF8, "UTF-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer converter.Close()
+
+	var buf bytes.Buffer
+	converter.Reset()
+	if err := converter.ConvertPart(&buf, strings.NewReader("ab")); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteByte(0xAA)
+	if err := converter.ConvertPart(&buf, strings.NewReader("cd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := converter.Flush(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	bom := data[:2]
+	if !bytes.Equal(bom, []byte{0xFF, 0xFE}) && !bytes.Equal(bom, []byte{0xFE, 0xFF}) {
+		t.Fatalf("stream does not start with BOM: % X", data)
+	}
+	if n := bytes.Count(data, bom); n != 1 {
+		t.Errorf("stream has %d BOMs: % X", n, data)
+	}
+	if len(data) != 2+4+1+4 {
+		t.Errorf("stream is %d bytes: % X", len(data), data)
+	}
+}
+
+// convertPairs texts converted between charsets other than utf-8, data of both charsets is
+// made from the text, so the pair is checked against the conversions tested above
+var convertPairs = []struct {
+	from string
+	to   string
+	text string
+}{
+	{"CP1251", "KOI8-R", "Съешь же ещё этих мягких французских булок"},
+	{"KOI8-R", "UTF-16LE", "Привет, мир"},
+	{"ISO-8859-1", "CP1252", "Ça va très bien, naïve café"},
+	{"ISO-8859-15", "UTF-16BE", "prix: 10€, œuvre"},
+	{"SHIFT_JIS", "EUC-JP", "日本語のテキスト、ｶﾀｶﾅ"},
+	{"EUC-JP", "ISO-2022-JP", "a日本語b漢字c"},
+	{"ISO-2022-JP", "SHIFT_JIS", "テスト1日本2"},
+	{"GB18030", "UTF-32LE", "中文文本𝄞"},
+	{"BIG5", "GB18030", "中文測試"},
+	{"EUC-KR", "UTF-16", "한국어 텍스트"},
+}
+
+func TestConvertPairs(t *testing.T) {
+	encode := func(t *testing.T, text, charset string) []byte {
+		encoder, err := NewConverter(CHARSET_UTF8, charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer encoder.Close()
+
+		data, err := encoder.ConvertBytes([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, tt := range convertPairs {
+		t.Run(tt.from+"-"+tt.to, func(t *testing.T) {
+			//text is long enough to cross the buffer of converter
+			text := strings.Repeat(tt.text+"\n", convertChunk/len(tt.text)+1)
+			from, to := encode(t, text, tt.from), encode(t, text, tt.to)
+
+			converter, err := NewConverter(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer converter.Close()
+
+			data, err := converter.ConvertBytes(from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, to) {
+				t.Errorf("converted %d bytes differ from expected %d bytes", len(data), len(to))
+			}
+		})
+	}
+}
+
+// TestConvertPartsStateful text of a stateful charset is converted by parts split at every
+// character, shift state is kept between parts, so the result is the same as of one conversion
+func TestConvertPartsStateful(t *testing.T) {
+	const charset = "ISO-2022-JP"
+	text := "ab日本語cd漢字ア"
+
+	encoder, err := NewConverter(CHARSET_UTF8, charset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+
+	whole, err := encoder.ConvertBytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range text {
+		var buf bytes.Buffer
+		encoder.Reset()
+		if err := encoder.ConvertPart(&buf, strings.NewReader(text[:i])); err != nil {
+			t.Fatalf("split at %d: %s", i, err)
+		}
+		if err := encoder.ConvertPart(&buf, strings.NewReader(text[i:])); err != nil {
+			t.Fatalf("split at %d: %s", i, err)
+		}
+		if err := encoder.Flush(&buf); err != nil {
+			t.Fatalf("split at %d: %s", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), whole) {
+			t.Errorf("split at %d: encoded % X, want % X", i, buf.Bytes(), whole)
+		}
+	}
+
+	decoder, err := NewConverter(charset, CHARSET_UTF8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	//data is split after every character and shift sequence, the second part starts in the
+	//shifted state
+	var splits []int
+	for i := 0; i < len(whole); {
+		switch {
+		case whole[i] == 0x1B:
+			i += 3
+		case i > 0 && bytes.LastIndex(whole[:i], []byte{0x1B, '$', 'B'}) > bytes.LastIndex(whole[:i], []byte{0x1B, '(', 'B'}):
+			i += 2
+		default:
+			i++
+		}
+		splits = append(splits, i)
+	}
+
+	for _, i := range splits {
+		var buf bytes.Buffer
+		decoder.Reset()
+		if err := decoder.ConvertPart(&buf, bytes.NewReader(whole[:i])); err != nil {
+			t.Fatalf("split at %d: %s", i, err)
+		}
+		if err := decoder.ConvertPart(&buf, bytes.NewReader(whole[i:])); err != nil {
+			t.Fatalf("split at %d: %s", i, err)
+		}
+		if err := decoder.Flush(&buf); err != nil {
+			t.Fatalf("split at %d: %s", i, err)
+		}
+		if buf.String() != text {
+			t.Errorf("split at %d: decoded %q, want %q", i, buf.String(), text)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/gtk"
+)
+
+// EditorConfig properties from .editorconfig files matched the file of tab
+type EditorConfig map[string]string
+
+// LoadEditorConfig read .editorconfig files from the directory of filename up to the root
+func LoadEditorConfig(filename string) EditorConfig {
+	if isURI(filename) {
+		return nil
+	}
+
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil
+	}
+
+	//files from the nearest to the root
+	var files []string
+	for dir := filepath.Dir(filename); ; dir = filepath.Dir(dir) {
+		ecfile := filepath.Join(dir, ".editorconfig")
+		if _, err := os.Stat(ecfile); err == nil {
+			files = append(files, ecfile)
+			if isEditorConfigRoot(ecfile) {
+				break
+			}
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+
+	ec := make(EditorConfig)
+	for i := len(files) - 1; i >= 0; i-- {
+		ec.parse(files[i], filename)
+	}
+
+	return ec
+}
+
+func isEditorConfigRoot(ecfile string) bool {
+	f, err := os.Open(ecfile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			return false
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "root" {
+			return strings.ToLower(strings.TrimSpace(kv[1])) == "true"
+		}
+	}
+	return false
+}
+
+// parse apply properties of sections matched filename
+func (ec EditorConfig) parse(ecfile, filename string) {
+	f, err := os.Open(ecfile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(ecfile)
+
+	var matched bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			matched = editorConfigMatch(dir, line[1:len(line)-1], filename)
+			continue
+		}
+
+		if !matched {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+		if key != "max_line_length" || value == "off" {
+			value = strings.ToLower(value)
+		}
+		ec[key] = value
+	}
+}
+
+func editorConfigMatch(dir, glob, filename string) bool {
+	glob = strings.TrimSpace(glob)
+	dir = filepath.ToSlash(dir)
+	filename = filepath.ToSlash(filename)
+
+	var expr string
+	if strings.Contains(glob, "/") {
+		expr = "^" + regexp.QuoteMeta(strings.TrimSuffix(dir, "/")) + "/" + editorConfigGlob(strings.TrimPrefix(glob, "/")) + "$"
+	} else {
+		expr = "^" + regexp.QuoteMeta(strings.TrimSuffix(dir, "/")) + "/(.*/)?" + editorConfigGlob(glob) + "$"
+	}
+
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return false
+	}
+	return reg.MatchString(filename)
+}
+
+var editorConfigRange = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)$`)
+
+// editorConfigGlob convert glob of section to regular expression
+func editorConfigGlob(glob string) string {
+	var expr strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end
+		case '{':
+			end := matchingBrace(glob, i)
+			if end < 0 {
+				expr.WriteString(`\{`)
+				continue
+			}
+
+			inner := glob[i+1 : end]
+			i = end
+
+			if m := editorConfigRange.FindStringSubmatch(inner); m != nil {
+				expr.WriteString(numericRange(m[1], m[2]))
+				continue
+			}
+
+			alts := splitBraces(inner)
+			if len(alts) < 2 {
+				expr.WriteString(`\{` + regexp.QuoteMeta(inner) + `\}`)
+				continue
+			}
+
+			for n, alt := range alts {
+				alts[n] = editorConfigGlob(alt)
+			}
+			expr.WriteString("(" + strings.Join(alts, "|") + ")")
+		default:
+			r, size := utf8.DecodeRuneInString(glob[i:])
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			i += size - 1
+		}
+	}
+
+	return expr.String()
+}
+
+func matchingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitBraces split alternatives of braces by commas on the top level
+func splitBraces(s string) []string {
+	var res []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				res = append(res, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(res, s[last:])
+}
+
+func numericRange(from, to string) string {
+	a, _ := strconv.Atoi(from)
+	b, _ := strconv.Atoi(to)
+	if a > b {
+		a, b = b, a
+	}
+
+	if b-a > 1024 {
+		return `[+-]?\d+`
+	}
+
+	var nums []string
+	for n := a; n <= b; n++ {
+		nums = append(nums, strconv.Itoa(n))
+	}
+	return "(" + strings.Join(nums, "|") + ")"
+}
+
+func (ec EditorConfig) Int(key string) (int, bool) {
+	n, err := strconv.Atoi(ec[key])
+	return n, err == nil && n > 0
+}
+
+func (ec EditorConfig) Bool(key string) bool {
+	return ec[key] == "true"
+}
+
+// Charset returns charset for iconv and true if file should have BOM
+func (ec EditorConfig) Charset() (string, bool) {
+	switch ec["charset"] {
+	case "latin1":
+		return "ISO-8859-1", false
+	case "utf-8":
+		return CHARSET_UTF8, false
+	case "utf-8-bom":
+		return CHARSET_UTF8, true
+	case "utf-16be":
+		return CHARSET_UTF16BE, false
+	case "utf-16le":
+		return CHARSET_UTF16LE, false
+	case "utf-16":
+		//byte order mark is written by iconv
+		return "UTF-16", false
+	}
+	return "", false
+}
+
+func (ec EditorConfig) LineEnding() string {
+	switch ec["end_of_line"] {
+	case "lf":
+		return LINE_ENDING_LF
+	case "crlf":
+		return LINE_ENDING_CRLF
+	case "cr":
+		return LINE_ENDING_CR
+	}
+	return ""
+}
+
+// applyEditorConfig set indentation and right margin, values of global config are used if not set
+func (t *Tab) applyEditorConfig() {
+	ec := t.editorconfig
+
+	tabWidth := conf.TextView.IndentWidth
+	indentWidth := -1
+	indentSpace := conf.TextView.IndentSpace
+
+	switch ec["indent_style"] {
+	case "space":
+		indentSpace = true
+	case "tab":
+		indentSpace = false
+	}
+
+	if n, ok := ec.Int("indent_size"); ok {
+		indentWidth = n
+		tabWidth = n
+	}
+	if n, ok := ec.Int("tab_width"); ok {
+		tabWidth = n
+		if ec["indent_size"] == "tab" {
+			indentWidth = n
+		}
+	}
+
+	t.sourceview.SetTabWidth(uint(tabWidth))
+	t.sourceview.SetIndentWidth(indentWidth)
+	t.sourceview.SetInsertSpacesInsteadOfTabs(indentSpace)
+
+	if n, ok := ec.Int("max_line_length"); ok {
+		t.sourceview.SetRightMarginPosition(uint(n))
+		t.sourceview.SetShowRightMargin(true)
+	} else {
+		t.sourceview.SetShowRightMargin(false)
+	}
+}
+
+// applyEditorConfigOnSave trim trailing whitespaces and insert or remove final newline in the
+// buffer, changes are one undo step
+func (t *Tab) applyEditorConfigOnSave() {
+	if t.editorconfig == nil || t.Encoding == CHARSET_BINARY || t.large != nil {
+		return
+	}
+
+	beginUserAction(t.sourcebuffer)
+	defer endUserAction(t.sourcebuffer)
+
+	if t.editorconfig.Bool("trim_trailing_whitespace") {
+		lines := strings.Split(t.GetText(true), "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			n := utf8.RuneCountInString(lines[i])
+			m := utf8.RuneCountInString(strings.TrimRight(lines[i], " \t"))
+			if n == m {
+				continue
+			}
+
+			var start, end gtk.TextIter
+			t.sourcebuffer.GetIterAtLineOffset(&start, i, m)
+			t.sourcebuffer.GetIterAtLineOffset(&end, i, n)
+			t.sourcebuffer.Delete(&start, &end)
+		}
+	}
+
+	switch t.editorconfig["insert_final_newline"] {
+	case "true":
+		text := t.GetText(true)
+		if len(text) > 0 && !strings.HasSuffix(text, "\n") {
+			var end gtk.TextIter
+			t.sourcebuffer.GetEndIter(&end)
+			t.sourcebuffer.Insert(&end, "\n")
+		}
+	case "false":
+		text := t.GetText(true)
+		if n := len(text) - len(strings.TrimRight(text, "\n")); n > 0 {
+			var start, end gtk.TextIter
+			t.sourcebuffer.GetEndIter(&end)
+			t.sourcebuffer.GetIterAtOffset(&start, end.GetOffset()-n)
+			t.sourcebuffer.Delete(&start, &end)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+)
+
+const (
+	encColName = iota
+	encColCharset
+)
+
+// encodingPreviewSize number of bytes of the file decoded in the preview of the dialog
+const encodingPreviewSize = 4096
+
+// charsetGroups groups of the encoding dialog, charset belongs to the first matched group
+var charsetGroups = []struct {
+	name string
+	reg  *regexp.Regexp
+}{
+	{"Unicode", regexp.MustCompile(`^(UTF|UCS|UNICODE|WCHAR_T)`)},
+	{"Western European", regexp.MustCompile(`8859[-_](1|3|10|14|15)(:|$)|^LATIN-?(1|3|6|8|9)$|^(CP|IBM|WINDOWS-)(437|850|858|1252)$|^MAC(INTOSH|ROMAN)?$|ROMAN8|ASCII|ANSI_X3|^ISO646`)},
+	{"Central European", regexp.MustCompile(`8859[-_](2|16)(:|$)|^LATIN-?(2|10)$|^(CP|IBM|WINDOWS-)(852|1250)$|CENTRALEUROPE`)},
+	{"Cyrillic", regexp.MustCompile(`8859[-_]5(:|$)|KOI|CYRILLIC|^(CP|IBM|WINDOWS-)(855|866|1251)$|^MIK$|UKRAINIAN`)},
+	{"Greek", regexp.MustCompile(`8859[-_]7(:|$)|GREEK|^(CP|IBM|WINDOWS-)(737|869|1253)$|ELOT`)},
+	{"Turkish", regexp.MustCompile(`8859[-_]9(:|$)|^LATIN-?5$|TURKISH|^(CP|IBM|WINDOWS-)(857|1254)$`)},
+	{"Baltic", regexp.MustCompile(`8859[-_](4|13)(:|$)|^LATIN-?(4|7)$|BALTIC|^(CP|IBM|WINDOWS-)(775|1257)$`)},
+	{"Hebrew", regexp.MustCompile(`8859[-_]8(:|$)|HEBREW|^(CP|IBM|WINDOWS-)(862|1255)$`)},
+	{"Arabic", regexp.MustCompile(`8859[-_]6(:|$)|ARABIC|^(CP|IBM|WINDOWS-)(720|864|1256)$|ASMO`)},
+	{"Thai", regexp.MustCompile(`TIS|8859[-_]11(:|$)|^(CP|IBM|WINDOWS-)874$|THAI`)},
+	{"Vietnamese", regexp.MustCompile(`VISCII|TCVN|^(CP|WINDOWS-)1258$|VIET`)},
+	{"Japanese", regexp.MustCompile(`JIS|EUC-?JP|2022-?JP|^CP932$|KANJI|WINDOWS-31J`)},
+	{"Chinese", regexp.MustCompile(`^GB|BIG-?5|EUC-?(CN|TW)|^HZ|^CP9(36|50)$|2022-?CN|^CN-`)},
+	{"Korean", regexp.MustCompile(`KR|JOHAB|^UHC$|^CP949$|KSC|KS_C`)},
+	{"IBM and EBCDIC", regexp.MustCompile(`EBCDIC|^IBM|^CP[0-9]+$|^[0-9]+$`)},
+}
+
+// knownCharsets charsets offered by the encoding dialog, names of glibc iconv and GNU libiconv,
+// aliases are listed separately, charsets not supported by iconv of the system are skipped
+var knownCharsets = []string{
+	"UTF-8", "UTF-16", "UTF-16LE", "UTF-16BE", "UTF-32", "UTF-32LE", "UTF-32BE", "UTF-7",
+	"UCS-2", "UCS-2LE", "UCS-2BE", "UCS-4", "UCS-4LE", "UCS-4BE",
+	"ASCII", "ISO-8859-1", "ISO-8859-2", "ISO-8859-3", "ISO-8859-4", "ISO-8859-5", "ISO-8859-6",
+	"ISO-8859-7", "ISO-8859-8", "ISO-8859-9", "ISO-8859-10", "ISO-8859-11", "ISO-8859-13",
+	"ISO-8859-14", "ISO-8859-15", "ISO-8859-16",
+	"WINDOWS-874", "WINDOWS-1250", "WINDOWS-1251", "WINDOWS-1252", "WINDOWS-1253",
+	"WINDOWS-1254", "WINDOWS-1255", "WINDOWS-1256", "WINDOWS-1257", "WINDOWS-1258",
+	"CP437", "CP737", "CP775", "CP850", "CP852", "CP855", "CP857", "CP858", "CP860", "CP861",
+	"CP862", "CP863", "CP864", "CP865", "CP866", "CP869", "CP1125", "CP1133",
+	"KOI8-R", "KOI8-U", "KOI8-RU", "KOI8-T", "PT154", "RK1048", "MIK", "GEORGIAN-PS", "ARMSCII-8",
+	"MACINTOSH", "MACCENTRALEUROPE", "MACCYRILLIC", "MACUKRAINE", "MACGREEK", "MACTURKISH",
+	"MACHEBREW", "MACARABIC", "MACTHAI", "MACROMANIA", "MACICELAND", "MACCROATIAN",
+	"HP-ROMAN8", "NEXTSTEP",
+	"TIS-620", "VISCII", "TCVN", "MULELAO-1",
+	"EUC-JP", "SHIFT_JIS", "CP932", "ISO-2022-JP", "ISO-2022-JP-1", "ISO-2022-JP-2",
+	"ISO-2022-JP-3", "EUC-JISX0213", "SHIFT_JISX0213",
+	"GB2312", "GBK", "CP936", "GB18030", "HZ", "EUC-CN", "ISO-2022-CN", "ISO-2022-CN-EXT",
+	"BIG5", "BIG5-HKSCS", "CP950", "EUC-TW",
+	"EUC-KR", "CP949", "UHC", "JOHAB", "ISO-2022-KR",
+	"IBM037", "IBM273", "IBM277", "IBM278", "IBM280", "IBM284", "IBM285", "IBM297", "IBM500",
+	"IBM875", "IBM1026", "IBM1047", "IBM1140",
+}
+
+var supportedCharsetsCache []string
+
+// supportedCharsets returns known charsets and favourites which iconv of the system can
+// convert to utf-8, every charset is checked once by opening a converter
+func supportedCharsets() []string {
+	if supportedCharsetsCache != nil {
+		return supportedCharsetsCache
+	}
+
+	used := make(map[string]bool)
+	for _, list := range [][]string{charsets(), knownCharsets} {
+		for _, c := range list {
+			if len(c) == 0 || c == CHARSET_BINARY || used[strings.ToUpper(c)] {
+				continue
+			}
+			used[strings.ToUpper(c)] = true
+
+			converter, err := NewConverter(c, CHARSET_UTF8)
+			if err != nil {
+				continue
+			}
+			converter.Close()
+			supportedCharsetsCache = append(supportedCharsetsCache, c)
+		}
+	}
+	sort.Strings(supportedCharsetsCache)
+	return supportedCharsetsCache
+}
+
+// charsetGroup returns name of the group of the charset
+func charsetGroup(charset string) string {
+	charset = strings.ToUpper(charset)
+	for _, g := range charsetGroups {
+		if g.reg.MatchString(charset) {
+			return g.name
+		}
+	}
+	return "Other"
+}
+
+// EncodingDialog choose charset from known charsets supported by iconv, highlighted charset
+// is previewed on the beginning of data
+type EncodingDialog struct {
+	dialog  *gtk.Dialog
+	filter  *gtk.Entry
+	store   *gtk.TreeStore
+	view    *gtk.TreeView
+	preview *gtk.TextView
+	favBtn  *gtk.Button
+
+	data []byte
+}
+
+// dialogEncoding returns chosen charset, empty if dialog is cancelled
+func dialogEncoding(title string, data []byte) string {
+	if len(data) > encodingPreviewSize {
+		data = data[:encodingPreviewSize]
+	}
+	d := &EncodingDialog{data: data}
+
+	d.dialog = gtk.NewDialog()
+	d.dialog.SetTitle(title)
+	d.dialog.SetTransientFor(ui.window)
+	d.dialog.SetModal(true)
+	d.dialog.SetDefaultSize(700, 450)
+
+	d.filter = gtk.NewEntry()
+	d.filter.SetTooltipText("Filter charsets by name")
+	d.filter.Connect("changed", d.Reload)
+
+	d.store = gtk.NewTreeStore(glib.G_TYPE_STRING, glib.G_TYPE_STRING)
+	d.view = gtk.NewTreeView()
+	d.view.SetModel(d.store)
+	d.view.SetHeadersVisible(false)
+	d.view.AppendColumn(gtk.NewTreeViewColumnWithAttributes("", gtk.NewCellRendererText(), "text", encColName))
+	d.view.GetSelection().Connect("changed", d.onSelect)
+	d.view.Connect("row-activated", func() {
+		if len(d.selected()) > 0 {
+			d.dialog.Response(gtk.RESPONSE_OK)
+		}
+	})
+
+	swin := gtk.NewScrolledWindow(nil, nil)
+	swin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	swin.SetShadowType(gtk.SHADOW_IN)
+	swin.SetSizeRequest(250, -1)
+	swin.Add(d.view)
+
+	d.preview = gtk.NewTextView()
+	d.preview.SetEditable(false)
+	d.preview.SetWrapMode(gtk.WRAP_CHAR)
+	d.preview.ModifyFontEasy(conf.TextView.Font)
+
+	pswin := gtk.NewScrolledWindow(nil, nil)
+	pswin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	pswin.SetShadowType(gtk.SHADOW_IN)
+	pswin.Add(d.preview)
+
+	paned := gtk.NewHPaned()
+	paned.Pack1(swin, false, false)
+	paned.Pack2(pswin, true, false)
+
+	d.favBtn = gtk.NewButtonWithLabel("Add to Favourites")
+	d.favBtn.SetTooltipText("Favourite charsets are shown in the Encoding menu after restart")
+	d.favBtn.Clicked(d.addFavourite)
+	d.favBtn.SetSensitive(false)
+
+	hbox := gtk.NewHBox(false, 0)
+	hbox.PackEnd(d.favBtn, false, false, 0)
+
+	vbox := d.dialog.GetVBox()
+	vbox.PackStart(d.filter, false, false, 5)
+	vbox.PackStart(paned, true, true, 0)
+	vbox.PackStart(hbox, false, false, 5)
+
+	d.dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	d.dialog.AddButton(gtk.STOCK_OK, gtk.RESPONSE_OK)
+	d.dialog.SetDefaultResponse(gtk.RESPONSE_OK)
+
+	d.Reload()
+	d.dialog.ShowAll()
+
+	var res string
+	if d.dialog.Run() == gtk.RESPONSE_OK {
+		res = d.selected()
+	}
+	d.dialog.Destroy()
+	return res
+}
+
+// Reload fill the list by charsets matched the filter, groups are expanded while filtering
+func (d *EncodingDialog) Reload() {
+	d.store.Clear()
+
+	query := strings.ToLower(strings.TrimSpace(d.filter.GetText()))
+
+	groups := make(map[string][]string)
+	for _, c := range supportedCharsets() {
+		if len(query) > 0 && !strings.Contains(strings.ToLower(c), query) {
+			continue
+		}
+		g := charsetGroup(c)
+		groups[g] = append(groups[g], c)
+	}
+
+	names := make([]string, 0, len(charsetGroups)+1)
+	for _, g := range charsetGroups {
+		names = append(names, g.name)
+	}
+	names = append(names, "Other")
+
+	for _, name := range names {
+		if len(groups[name]) == 0 {
+			continue
+		}
+
+		var parent gtk.TreeIter
+		d.store.Append(&parent, nil)
+		d.store.Set(&parent, name, "")
+
+		for _, c := range groups[name] {
+			var iter gtk.TreeIter
+			d.store.Append(&iter, &parent)
+			d.store.Set(&iter, c, c)
+		}
+	}
+
+	if len(query) > 0 {
+		d.view.ExpandAll()
+	}
+}
+
+// selected returns highlighted charset, empty for group rows
+func (d *EncodingDialog) selected() string {
+	var iter gtk.TreeIter
+	if !d.view.GetSelection().GetSelected(&iter) {
+		return ""
+	}
+
+	var val glib.GValue
+	d.store.GetValue(&iter, encColCharset, &val)
+	return val.GetString()
+}
+
+func (d *EncodingDialog) onSelect() {
+	charset := d.selected()
+	d.favBtn.SetSensitive(len(charset) > 0 && !isFavouriteCharset(charset))
+	if len(charset) == 0 {
+		return
+	}
+
+	segments, err := decodeEscaped(d.data, charset)
+	if err != nil {
+		d.preview.GetBuffer().SetText(err.Error())
+		return
+	}
+	text, _ := joinSegments(segments)
+	d.preview.GetBuffer().SetText(text)
+}
+
+func (d *EncodingDialog) addFavourite() {
+	charset := d.selected()
+	if len(charset) == 0 || isFavouriteCharset(charset) {
+		return
+	}
+
+	conf.Encodings.Favourites = append(conf.Encodings.Favourites, charset)
+	conf.Write()
+	d.favBtn.SetSensitive(false)
+}
+
+func isFavouriteCharset(charset string) bool {
+	for _, c := range conf.Encodings.Favourites {
+		if strings.EqualFold(c, charset) {
+			return true
+		}
+	}
+	return false
+}
+
+// previewData returns bytes of the file for the preview of encoding dialog
+func (t *Tab) previewData() []byte {
+	if len(t.Filename) == 0 || t.large != nil {
+		return []byte(t.GetText(true))
+	}
+
+	data, _, err := readFileData(t.Filename)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	data, _ = stripBOM(data)
+	return data
+}
+
+// addEncodingAction add charset chosen outside the favourites to the Encoding menu
+func (ui *UI) addEncodingAction(charset string) *gtk.RadioAction {
+	ra := ui.newRadioAction(charset, charset, "", false, len(ui.encodings), ui.changeEncodingCurrentTab, charset)
+	ra.SetGroup(ui.encodingsGroup)
+	ui.encodingsGroup = ra.GetGroup()
+	ui.encodings[charset] = ra
+
+	xml := `<ui><menubar name='MenuBar'><menu action='File'><menu action='Encoding'>
+		<placeholder name='OtherEncodings'><menuitem action='` + charset + `' /></placeholder>
+		</menu></menu></menubar></ui>`
+	if err := ui.menu.uiManager.AddUIFromString(xml); err != nil {
+		log.Println("failed add encoding to menu,", err)
+	}
+	return ra
+}
+
+// ChooseEncodingCurrentTab decode the current tab from charset chosen in the dialog
+func (ui *UI) ChooseEncodingCurrentTab() {
+	t := ui.GetCurrentTab()
+	if t == nil {
+		return
+	}
+
+	charset := dialogEncoding("Encoding of "+t.label.GetText(), t.previewData())
+	if len(charset) == 0 {
+		return
+	}
+	ui.setEncodingCurrentTab(charset)
+	t.UpdateMenuSeleted()
+}
+
+// ReopenOtherCurrentTab read the file again in charset chosen in the dialog
+func (ui *UI) ReopenOtherCurrentTab() {
+	t := ui.GetCurrentTab()
+	if t == nil || len(t.Filename) == 0 {
+		return
+	}
+
+	charset := dialogEncoding("Reopen "+t.label.GetText(), t.previewData())
+	if len(charset) == 0 {
+		return
+	}
+	ui.reopenCurrentTab(charset)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/gtk"
+)
+
+// escapeMaxPercent max share of undecodable bytes to open the file as text, otherwise it is
+// opened in the hex view
+const escapeMaxPercent = 5
+
+// escapeRegexp undecodable byte is shown in the text as `\xNN` marked by the escape tag
+var escapeRegexp = regexp.MustCompile(`\\x[0-9A-F]{2}`)
+
+// segment part of decoded text, escaped segment is one undecodable byte
+type segment struct {
+	data    []byte
+	escaped bool
+}
+
+// decodeEscaped convert data to utf-8, bytes which are not valid in the charset are kept
+// as escaped segments instead of failing the whole conversion
+func decodeEscaped(data []byte, from string) ([]segment, error) {
+	if from == CHARSET_UTF8 || from == CHARSET_ASCII {
+		return splitInvalidUTF8(data), nil
+	}
+
+	converter, err := NewConverter(from, CHARSET_UTF8)
+	if err != nil {
+		return nil, err
+	}
+	defer converter.Close()
+
+	sw := new(segmentWriter)
+	converter.Invalid = sw.escape
+	if err := converter.Convert(sw, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return sw.segments, nil
+}
+
+// segmentWriter collects converted text and undecodable bytes in order
+type segmentWriter struct {
+	segments []segment
+}
+
+func (sw *segmentWriter) Write(p []byte) (int, error) {
+	if n := len(sw.segments); n > 0 && !sw.segments[n-1].escaped {
+		sw.segments[n-1].data = append(sw.segments[n-1].data, p...)
+	} else {
+		sw.segments = append(sw.segments, segment{data: append([]byte{}, p...)})
+	}
+	return len(p), nil
+}
+
+func (sw *segmentWriter) escape(b byte) {
+	sw.segments = append(sw.segments, segment{data: []byte{b}, escaped: true})
+}
+
+func splitInvalidUTF8(data []byte) []segment {
+	var segments []segment
+	var start int
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size <= 1 {
+			if i > start {
+				segments = append(segments, segment{data: data[start:i]})
+			}
+			segments = append(segments, segment{data: data[i : i+1], escaped: true})
+			i++
+			start = i
+			continue
+		}
+		i += size
+	}
+	if start < len(data) {
+		segments = append(segments, segment{data: data[start:]})
+	}
+	return segments
+}
+
+// countEscaped returns number of undecodable bytes
+func countEscaped(segments []segment) int {
+	var n int
+	for _, s := range segments {
+		if s.escaped {
+			n++
+		}
+	}
+	return n
+}
+
+// joinSegments returns text with normalized line endings and character offsets of escapes
+func joinSegments(segments []segment) (string, []int) {
+	var text bytes.Buffer
+	var escapes []int
+	var offset int
+	for _, s := range segments {
+		data := s.data
+		if s.escaped {
+			data = []byte(fmt.Sprintf(`\x%02X`, s.data[0]))
+			escapes = append(escapes, offset)
+		} else {
+			data = NormalizeLineEndings(data)
+		}
+		text.Write(data)
+		offset += utf8.RuneCount(data)
+	}
+	return text.String(), escapes
+}
+
+// textOfSegments returns decoded text without escapes, ex: for detection of line endings
+func textOfSegments(segments []segment) []byte {
+	var text []byte
+	for _, s := range segments {
+		if !s.escaped {
+			text = append(text, s.data...)
+		}
+	}
+	return text
+}
+
+// tagEscapes mark escapes of undecodable bytes after the text is set to the buffer
+func (t *Tab) tagEscapes() {
+	escapes := t.escapes
+	t.escapes = nil
+	if len(escapes) == 0 {
+		return
+	}
+
+	if t.tagEscape == nil {
+		t.tagEscape = t.sourcebuffer.CreateTag("escape", map[string]interface{}{
+			"foreground": "#aa0000",
+			"background": "#dddddd",
+		})
+	}
+
+	var start, end gtk.TextIter
+	for _, offset := range escapes {
+		t.sourcebuffer.GetIterAtOffset(&start, offset)
+		t.sourcebuffer.GetIterAtOffset(&end, offset+4)
+		t.sourcebuffer.ApplyTag(t.tagEscape, &start, &end)
+	}
+}
+
+// taggedEscapes returns character offsets of escapes tagged in the buffer
+func (t *Tab) taggedEscapes() []int {
+	if t.tagEscape == nil {
+		return nil
+	}
+
+	var escapes []int
+	for _, r := range tagRanges(t.sourcebuffer, t.tagEscape) {
+		//adjacent escapes are one range of the tag
+		for offset := r[0]; offset+4 <= r[1]; offset += 4 {
+			escapes = append(escapes, offset)
+		}
+	}
+	return escapes
+}
+
+// escapesByLine returns columns of escapes, in characters, keyed by text of the line
+func escapesByLine(text string, escapes []int) map[string][]int {
+	res := make(map[string][]int)
+	lines := strings.Split(text, "\n")
+
+	var line, lineStart int
+	for _, offset := range escapes {
+		for line < len(lines)-1 && offset > lineStart+utf8.RuneCountInString(lines[line]) {
+			lineStart += utf8.RuneCountInString(lines[line]) + 1
+			line++
+		}
+		res[lines[line]] = append(res[lines[line]], offset-lineStart)
+	}
+	return res
+}
+
+// lineEscapes returns character offsets of escapes in text for the columns of escapesByLine
+func lineEscapes(text string, byLine map[string][]int) []int {
+	var escapes []int
+	var lineStart int
+	for _, line := range strings.Split(text, "\n") {
+		cols := byLine[line]
+		sort.Ints(cols)
+		for i, col := range cols {
+			if i == 0 || col != cols[i-1] {
+				escapes = append(escapes, lineStart+col)
+			}
+		}
+		lineStart += utf8.RuneCountInString(line) + 1
+	}
+	return escapes
+}
+
+// EncodeText convert text of the buffer to the encoding and line ending of the file,
+// tagged escapes are written back as original bytes
+func (t *Tab) EncodeText() ([]byte, error) {
+	var converter *Converter
+	if t.Encoding != CHARSET_UTF8 && t.Encoding != CHARSET_ASCII {
+		var err error
+		converter, err = NewConverter(CHARSET_UTF8, t.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		defer converter.Close()
+	}
+
+	//text is one stream, so BOM of UTF-16/32 is written once and escaped bytes are placed
+	//between its parts
+	if converter != nil {
+		converter.Reset()
+	}
+
+	var data bytes.Buffer
+	for _, s := range t.escapedSegments() {
+		if s.escaped {
+			data.Write(s.data)
+			continue
+		}
+
+		text := ConvertLineEndings(s.data, t.LineEnding)
+		if converter == nil {
+			data.Write(text)
+			continue
+		}
+		if err := converter.ConvertPart(&data, bytes.NewReader(text)); err != nil {
+			return nil, err
+		}
+	}
+
+	if converter != nil {
+		if err := converter.Flush(&data); err != nil {
+			return nil, err
+		}
+	}
+	return data.Bytes(), nil
+}
+
+// escapedSegments split text of the buffer by tagged escapes, text typed by user which looks
+// like escape is not tagged and stays as is
+func (t *Tab) escapedSegments() []segment {
+	if t.tagEscape == nil {
+		return []segment{{data: []byte(t.GetText(true))}}
+	}
+	text := []rune(t.GetText(true))
+
+	var segments []segment
+	var prev int
+	for _, r := range tagRanges(t.sourcebuffer, t.tagEscape) {
+		if r[1] > len(text) {
+			r[1] = len(text)
+		}
+		tagged := string(text[r[0]:r[1]])
+		for _, index := range escapeRegexp.FindAllStringIndex(tagged, -1) {
+			start := r[0] + utf8.RuneCountInString(tagged[:index[0]])
+			end := start + 4
+			b, _ := strconv.ParseUint(string(text[start+2:end]), 16, 8)
+			segments = append(segments, segment{data: []byte(string(text[prev:start]))})
+			segments = append(segments, segment{data: []byte{byte(b)}, escaped: true})
+			prev = end
+		}
+	}
+	segments = append(segments, segment{data: []byte(string(text[prev:]))})
+	return segments
+}
+
+// untaggedEscapes returns character offsets of text which looks like escape but is not tagged,
+// tag is lost by undo, redo and copying, so such text may be an undecodable byte as well
+func (t *Tab) untaggedEscapes() []int {
+	if t.tagEscape == nil {
+		return nil
+	}
+	text := t.GetText(true)
+	ranges := tagRanges(t.sourcebuffer, t.tagEscape)
+
+	var offsets []int
+	var offset, prev, i int
+	for _, index := range escapeRegexp.FindAllStringIndex(text, -1) {
+		offset += utf8.RuneCountInString(text[prev:index[0]])
+		prev = index[0]
+
+		for i < len(ranges) && ranges[i][1] <= offset {
+			i++
+		}
+		if i < len(ranges) && ranges[i][0] <= offset && offset+4 <= ranges[i][1] {
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// CheckEscapes ask how to save text which looks like escapes of undecodable bytes but lost
+// the tag, user chooses to write them as bytes, as text or cancel saving
+func (t *Tab) CheckEscapes() bool {
+	if t.large != nil || t.Encoding == CHARSET_BINARY {
+		return true
+	}
+
+	offsets := t.untaggedEscapes()
+	if len(offsets) == 0 {
+		return true
+	}
+
+	var start, end gtk.TextIter
+	t.sourcebuffer.GetIterAtOffset(&start, offsets[0])
+	t.Scroll(start)
+
+	dialog := gtk.NewDialog()
+	dialog.SetTitle("Escaped bytes")
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+
+	message := fmt.Sprintf("%d sequences like `\\xNN` in `%s` are not marked as undecodable bytes, "+
+		"the mark is lost by undo or copying. Save them as original bytes or as text?", len(offsets), t.label.GetText())
+	label := gtk.NewLabel(message)
+	label.SetLineWrap(true)
+	dialog.GetVBox().PackStart(label, false, false, 5)
+
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton("Save as Text", gtk.RESPONSE_APPLY)
+	dialog.AddButton("Save as Bytes", gtk.RESPONSE_ACCEPT)
+	dialog.SetDefaultResponse(gtk.RESPONSE_ACCEPT)
+	dialog.ShowAll()
+
+	response := dialog.Run()
+	dialog.Destroy()
+
+	switch response {
+	case gtk.RESPONSE_ACCEPT:
+		for _, offset := range offsets {
+			t.sourcebuffer.GetIterAtOffset(&start, offset)
+			t.sourcebuffer.GetIterAtOffset(&end, offset+4)
+			t.sourcebuffer.ApplyTag(t.tagEscape, &start, &end)
+		}
+		return true
+	case gtk.RESPONSE_APPLY:
+		return true
+	}
+	return false
+}
+
+// mostlyUTF8 returns true if share of invalid bytes is small enough to escape them
+func mostlyUTF8(data []byte) bool {
+	n := countEscaped(splitInvalidUTF8(data))
+	return n*100 <= len(data)*escapeMaxPercent
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/mattn/go-gtk/gdk"
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+)
+
+const (
+	treeColName = iota
+	treeColPath
+	treeColStock
+)
+
+// maxFilteredFiles limit of files shown by the filter, the whole project is walked to find them
+const maxFilteredFiles = 1000
+
+// FileTree side panel with files of the opened directory, subdirectories are read on expand
+type FileTree struct {
+	root string
+
+	box    *gtk.VBox
+	filter *gtk.Entry
+	store  *gtk.TreeStore
+	view   *gtk.TreeView
+
+	ignores map[string][]ignoreRule
+}
+
+// ignoreRule one pattern of .gitignore, patterns are matched against path relative to the .gitignore
+type ignoreRule struct {
+	base    string
+	reg     *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+func NewFileTree() *FileTree {
+	ft := &FileTree{ignores: make(map[string][]ignoreRule)}
+
+	ft.filter = gtk.NewEntry()
+	ft.filter.SetTooltipText("Filter files by name")
+	ft.filter.Connect("changed", ft.Reload)
+
+	ft.store = gtk.NewTreeStore(glib.G_TYPE_STRING, glib.G_TYPE_STRING, glib.G_TYPE_STRING)
+
+	ft.view = gtk.NewTreeView()
+	ft.view.SetModel(ft.store)
+	ft.view.SetHeadersVisible(false)
+
+	column := gtk.NewTreeViewColumn()
+	icon := gtk.NewCellRendererPixbuf()
+	column.PackStart(icon, false)
+	column.AddAttribute(icon, "stock-id", treeColStock)
+	name := gtk.NewCellRendererText()
+	column.PackStart(name, true)
+	column.AddAttribute(name, "text", treeColName)
+	ft.view.AppendColumn(column)
+
+	ft.view.Connect("row-expanded", ft.onRowExpanded)
+	ft.view.Connect("row-activated", ft.onRowActivated)
+	ft.view.Connect("button-release-event", ft.onButtonRelease)
+
+	swin := gtk.NewScrolledWindow(nil, nil)
+	swin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	swin.SetShadowType(gtk.SHADOW_IN)
+	swin.Add(ft.view)
+
+	ft.box = gtk.NewVBox(false, 0)
+	ft.box.PackStart(ft.filter, false, false, 0)
+	ft.box.PackStart(swin, true, true, 0)
+
+	return ft
+}
+
+// Open show files of the directory
+func (ft *FileTree) Open(dir string) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	ft.root = dir
+	ft.ignores = make(map[string][]ignoreRule)
+	ft.Reload()
+}
+
+// Reload read the tree again, with not empty filter it shows only matched files
+func (ft *FileTree) Reload() {
+	ft.store.Clear()
+	if len(ft.root) == 0 {
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(ft.filter.GetText()))
+	if len(query) == 0 {
+		ft.loadDir(nil, ft.root)
+		return
+	}
+
+	ft.loadFiltered(query)
+	ft.view.ExpandAll()
+}
+
+// loadDir append entries of the directory, directories get placeholder child to be expandable
+func (ft *FileTree) loadDir(parent *gtk.TreeIter, dir string) {
+	for _, e := range ft.readDir(dir) {
+		filename := filepath.Join(dir, e.Name())
+
+		var iter gtk.TreeIter
+		ft.store.Append(&iter, parent)
+
+		if e.IsDir() {
+			ft.store.Set(&iter, e.Name(), filename, gtk.STOCK_DIRECTORY)
+
+			var placeholder gtk.TreeIter
+			ft.store.Append(&placeholder, &iter)
+			ft.store.Set(&placeholder, "", "", "")
+		} else {
+			ft.store.Set(&iter, e.Name(), filename, gtk.STOCK_FILE)
+		}
+	}
+}
+
+// readDir returns not ignored entries of the directory, directories first
+func (ft *FileTree) readDir(dir string) []os.DirEntry {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	var res []os.DirEntry
+	for _, e := range entries {
+		if !ft.ignored(dir, e.Name(), e.IsDir()) {
+			res = append(res, e)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].IsDir() && !res[j].IsDir()
+	})
+
+	return res
+}
+
+func (ft *FileTree) loadFiltered(query string) {
+	parents := map[string]*gtk.TreeIter{ft.root: nil}
+
+	//add directory and all its parents to the tree
+	var dirIter func(dir string) *gtk.TreeIter
+	dirIter = func(dir string) *gtk.TreeIter {
+		if iter, ok := parents[dir]; ok {
+			return iter
+		}
+
+		parent := dirIter(filepath.Dir(dir))
+		iter := new(gtk.TreeIter)
+		ft.store.Append(iter, parent)
+		ft.store.Set(iter, filepath.Base(dir), dir, gtk.STOCK_DIRECTORY)
+		parents[dir] = iter
+		return iter
+	}
+
+	count := 0
+	filepath.Walk(ft.root, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || filename == ft.root {
+			return nil
+		}
+		if count >= maxFilteredFiles {
+			return filepath.SkipAll
+		}
+
+		dir := filepath.Dir(filename)
+		if ft.ignored(dir, info.Name(), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || !strings.Contains(strings.ToLower(info.Name()), query) {
+			return nil
+		}
+
+		var iter gtk.TreeIter
+		ft.store.Append(&iter, dirIter(dir))
+		ft.store.Set(&iter, info.Name(), filename, gtk.STOCK_FILE)
+		count++
+		return nil
+	})
+}
+
+// rules returns .gitignore rules which apply to entries of the directory
+func (ft *FileTree) rules(dir string) []ignoreRule {
+	if rules, ok := ft.ignores[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	if dir != ft.root && strings.HasPrefix(dir, ft.root) {
+		rules = append(rules, ft.rules(filepath.Dir(dir))...)
+	}
+	rules = append(rules, readGitignore(dir)...)
+
+	ft.ignores[dir] = rules
+	return rules
+}
+
+// ignored check the entry by .gitignore files, the last matched rule wins
+func (ft *FileTree) ignored(dir, name string, isDir bool) bool {
+	if name == ".git" {
+		return true
+	}
+
+	filename := filepath.ToSlash(filepath.Join(dir, name))
+
+	ignored := false
+	for _, r := range ft.rules(dir) {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel := strings.TrimPrefix(filename, r.base+"/")
+		if r.reg.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+func readGitignore(dir string) []ignoreRule {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return nil
+	}
+
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+
+		//trailing spaces are ignored unless they are escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		r := ignoreRule{base: filepath.ToSlash(dir)}
+		if line[0] == '!' {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		reg, err := gitignoreRegexp(line)
+		if err != nil {
+			continue
+		}
+		r.reg = reg
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// gitignoreRegexp convert pattern of .gitignore to regular expression matched against path
+// relative to the directory of .gitignore. Pattern with slash is anchored to the directory,
+// without slash it matches at any level. `*`, `?` and `[...]` do not match slash, `**/`
+// matches any number of directories and trailing `/**` everything inside
+func gitignoreRegexp(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := "^"
+	if !anchored {
+		expr += "(.*/)?"
+	}
+
+	for i := 0; i < len(pattern); {
+		atStart := i == 0 || pattern[i-1] == '/'
+
+		switch {
+		case atStart && strings.HasPrefix(pattern[i:], "**/"):
+			expr += "(.*/)?"
+			i += 3
+		case atStart && pattern[i:] == "**":
+			expr += ".*"
+			i += 2
+		case pattern[i] == '*':
+			expr += "[^/]*"
+			i++
+		case pattern[i] == '?':
+			expr += "[^/]"
+			i++
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			expr += regexp.QuoteMeta(pattern[i+1 : i+2])
+			i += 2
+		case pattern[i] == '[' && strings.IndexByte(pattern[i+1:], ']') > 0:
+			end := i + 1 + strings.IndexByte(pattern[i+1:], ']')
+			class := pattern[i+1 : end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			expr += "[" + strings.Replace(class, "\\", "\\\\", -1) + "]"
+			i = end + 1
+		default:
+			expr += regexp.QuoteMeta(pattern[i : i+1])
+			i++
+		}
+	}
+
+	return regexp.Compile(expr + "$")
+}
+
+func (ft *FileTree) getPath(iter *gtk.TreeIter) string {
+	var val glib.GValue
+	ft.store.GetValue(iter, treeColPath, &val)
+	return val.GetString()
+}
+
+func (ft *FileTree) onRowExpanded(ctx *glib.CallbackContext) {
+	iter := (*gtk.TreeIter)(unsafe.Pointer(ctx.Args(0)))
+
+	var child gtk.TreeIter
+	if !ft.store.IterChildren(&child, iter) || len(ft.getPath(&child)) > 0 {
+		return
+	}
+
+	//replace placeholder by content of the directory
+	ft.store.Remove(&child)
+	ft.loadDir(iter, ft.getPath(iter))
+
+	path := ft.store.GetPath(iter)
+	ft.view.ExpandRow(path, false)
+}
+
+func (ft *FileTree) onRowActivated() {
+	filename, ok := ft.selected()
+	if !ok {
+		return
+	}
+
+	if stat, err := os.Stat(filename); err == nil && !stat.IsDir() {
+		ui.NewTab(filename)
+	}
+}
+
+// selected returns path of the selected row
+func (ft *FileTree) selected() (string, bool) {
+	var iter gtk.TreeIter
+	if !ft.view.GetSelection().GetSelected(&iter) {
+		return "", false
+	}
+
+	filename := ft.getPath(&iter)
+	return filename, len(filename) > 0
+}
+
+// selectedDir returns the selected directory, directory of the selected file or root
+func (ft *FileTree) selectedDir() string {
+	filename, ok := ft.selected()
+	if !ok {
+		return ft.root
+	}
+
+	if stat, err := os.Stat(filename); err == nil && stat.IsDir() {
+		return filename
+	}
+	return filepath.Dir(filename)
+}
+
+func (ft *FileTree) onButtonRelease(ctx *glib.CallbackContext) {
+	arg := ctx.Args(0)
+	event := *(**gdk.EventButton)(unsafe.Pointer(&arg))
+
+	if event.Button != 3 || len(ft.root) == 0 {
+		return
+	}
+
+	menu := gtk.NewMenu()
+	ft.appendMenuItem(menu, "New File...", ft.NewFile)
+	ft.appendMenuItem(menu, "New Folder...", ft.NewFolder)
+
+	if _, ok := ft.selected(); ok {
+		ft.appendMenuItem(menu, "Rename...", ft.Rename)
+		ft.appendMenuItem(menu, "Delete", ft.Delete)
+	}
+
+	menu.Append(gtk.NewSeparatorMenuItem())
+	ft.appendMenuItem(menu, "Refresh", ft.Refresh)
+
+	menu.ShowAll()
+	menu.Popup(nil, nil, nil, nil, uint(event.Button), event.Time)
+}
+
+func (ft *FileTree) appendMenuItem(menu *gtk.Menu, label string, f func()) {
+	item := gtk.NewMenuItemWithLabel(label)
+	item.Connect("activate", f)
+	menu.Append(item)
+}
+
+// Refresh forget cached .gitignore rules and read the tree again
+func (ft *FileTree) Refresh() {
+	ft.ignores = make(map[string][]ignoreRule)
+	ft.Reload()
+}
+
+func (ft *FileTree) NewFile() {
+	name := dialogFileName("New File", "")
+	if len(name) == 0 {
+		return
+	}
+
+	filename := filepath.Join(ft.selectedDir(), name)
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		err := fmt.Errorf("failed create file `%s`, %s", filename, err)
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+	f.Close()
+
+	ft.Reload()
+	ui.NewTab(filename)
+}
+
+func (ft *FileTree) NewFolder() {
+	name := dialogFileName("New Folder", "")
+	if len(name) == 0 {
+		return
+	}
+
+	dir := filepath.Join(ft.selectedDir(), name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		err := fmt.Errorf("failed create directory `%s`, %s", dir, err)
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	ft.Reload()
+}
+
+// Rename rename selected file or directory, open tabs follow renamed files
+func (ft *FileTree) Rename() {
+	oldname, ok := ft.selected()
+	if !ok {
+		return
+	}
+
+	name := dialogFileName("Rename", filepath.Base(oldname))
+	if len(name) == 0 || name == filepath.Base(oldname) {
+		return
+	}
+
+	newname := filepath.Join(filepath.Dir(oldname), name)
+	if _, err := os.Lstat(newname); err == nil {
+		errorMessage(fmt.Errorf("file `%s` already exists", newname))
+		return
+	}
+
+	if err := os.Rename(oldname, newname); err != nil {
+		err := fmt.Errorf("failed rename `%s`, %s", oldname, err)
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	for _, t := range ui.tabs {
+		if t.Filename == oldname || strings.HasPrefix(t.Filename, oldname+"/") {
+			t.SetFilename(newname + strings.TrimPrefix(t.Filename, oldname))
+			t.UpdateDiskState(t.diskText)
+		}
+	}
+
+	ft.Reload()
+}
+
+// Delete remove selected file or directory with its content after confirmation
+func (ft *FileTree) Delete() {
+	filename, ok := ft.selected()
+	if !ok {
+		return
+	}
+
+	message := fmt.Sprintf("Delete `%s`?", filename)
+	if stat, err := os.Stat(filename); err == nil && stat.IsDir() {
+		message = fmt.Sprintf("Delete directory `%s` with all its content?", filename)
+	}
+
+	dialog := gtk.NewMessageDialog(ui.window, gtk.DIALOG_MODAL, gtk.MESSAGE_QUESTION, gtk.BUTTONS_YES_NO, "%s", message)
+	response := dialog.Run()
+	dialog.Destroy()
+
+	if response != gtk.RESPONSE_YES {
+		return
+	}
+
+	if err := os.RemoveAll(filename); err != nil {
+		err := fmt.Errorf("failed delete `%s`, %s", filename, err)
+		errorMessage(err)
+		log.Println(err)
+	}
+
+	ft.Reload()
+}
+
+func dialogFileName(title, name string) string {
+	dialog := gtk.NewDialog()
+	dialog.SetTitle(title)
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+
+	entry := gtk.NewEntry()
+	entry.SetText(name)
+	entry.SetActivatesDefault(true)
+
+	dialog.GetVBox().PackStart(entry, false, false, 5)
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton(gtk.STOCK_OK, gtk.RESPONSE_OK)
+	dialog.SetDefaultResponse(gtk.RESPONSE_OK)
+	dialog.ShowAll()
+
+	var res string
+	if dialog.Run() == gtk.RESPONSE_OK {
+		res = strings.TrimSpace(entry.GetText())
+	}
+	dialog.Destroy()
+
+	if strings.ContainsAny(res, "/\\") || res == "." || res == ".." {
+		errorMessage(fmt.Errorf("invalid file name `%s`", res))
+		return ""
+	}
+
+	return res
+}
+
+// OpenDir show the directory in the side panel
+func (ui *UI) OpenDir(dir string) {
+	ui.filetree.Open(dir)
+
+	ui.filetree.box.SetVisible(true)
+	ui.filetree.box.ShowAll()
+
+	ui.NoActivate = true
+	ui.fileTreeAction.SetActive(true)
+	ui.NoActivate = false
+}
+
+func (ui *UI) ToggleFileTree() {
+	if ui.NoActivate {
+		return
+	}
+	ui.filetree.box.SetVisible(ui.fileTreeAction.GetActive())
+}
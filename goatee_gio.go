@@ -0,0 +1,247 @@
+package main
+
+/*
+#cgo pkg-config: gio-2.0
+#include <stdlib.h>
+#include <gio/gio.h>
+
+static GFile *goatee_file_new(const char *uri) {
+	return g_file_new_for_uri(uri);
+}
+
+static gboolean goatee_file_can_write(GFile *file) {
+	GFileInfo *info = g_file_query_info(file, G_FILE_ATTRIBUTE_ACCESS_CAN_WRITE, G_FILE_QUERY_INFO_NONE, NULL, NULL);
+	if (info == NULL) {
+		return TRUE;
+	}
+
+	gboolean res = TRUE;
+	if (g_file_info_has_attribute(info, G_FILE_ATTRIBUTE_ACCESS_CAN_WRITE)) {
+		res = g_file_info_get_attribute_boolean(info, G_FILE_ATTRIBUTE_ACCESS_CAN_WRITE);
+	}
+	g_object_unref(info);
+	return res;
+}
+
+extern void goateeFileChanged(guintptr id, GFileMonitorEvent event);
+
+static void goatee_monitor_changed(GFileMonitor *monitor, GFile *file, GFile *other, GFileMonitorEvent event, gpointer id) {
+	goateeFileChanged((guintptr)id, event);
+}
+
+static GFileMonitor *goatee_monitor_file(const char *path, guintptr id) {
+	GFile *file = g_file_new_for_path(path);
+	GFileMonitor *monitor = g_file_monitor_file(file, G_FILE_MONITOR_NONE, NULL, NULL);
+	g_object_unref(file);
+	if (monitor == NULL) {
+		return NULL;
+	}
+
+	g_signal_connect(monitor, "changed", G_CALLBACK(goatee_monitor_changed), (gpointer)id);
+	return monitor;
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unsafe"
+
+	"github.com/mattn/go-gtk/gtk"
+)
+
+// gioChunk size of buffer for reading and writing GIO streams
+const gioChunk = 64 << 10
+
+// isURI returns true for locations with scheme, ex: `sftp://host/file`, they are read and
+// written through GIO, so gvfs fuse mount is not required. Relative names with colon,
+// ex: `notes:old.txt`, are not locations
+func isURI(filename string) bool {
+	i := strings.Index(filename, "://")
+	if i < 2 {
+		return false
+	}
+	u, err := url.Parse(filename)
+	return err == nil && u.Scheme == strings.ToLower(filename[:i])
+}
+
+// gioLocalPath returns path of the location if it is native, ex: `file:///tmp/file`, empty string
+// for remote locations even if they are mounted by gvfs fuse
+func gioLocalPath(uri string) string {
+	file := gioFile(uri)
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(file)))
+
+	if C.g_file_is_native(file) == C.FALSE {
+		return ""
+	}
+	cpath := C.g_file_get_path(file)
+	if cpath == nil {
+		return ""
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(cpath)))
+	return C.GoString(cpath)
+}
+
+// gioError convert GError to go error and free it
+func gioError(gerr *C.GError) error {
+	if gerr == nil {
+		return errors.New("unknown error")
+	}
+	defer C.g_error_free(gerr)
+
+	message := C.GoString((*C.char)(gerr.message))
+	if gerr.domain == C.g_io_error_quark() && gerr.code == C.G_IO_ERROR_NOT_MOUNTED {
+		message += ", mount the location first, ex: `gio mount URI`"
+	}
+	return errors.New(message)
+}
+
+func gioFile(uri string) *C.GFile {
+	curi := C.CString(uri)
+	defer C.free(unsafe.Pointer(curi))
+	return C.goatee_file_new(curi)
+}
+
+// gioReadURI read content of the location by GFile input stream
+func gioReadURI(uri string) ([]byte, error) {
+	file := gioFile(uri)
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(file)))
+
+	var gerr *C.GError
+	stream := C.g_file_read(file, nil, &gerr)
+	if stream == nil {
+		return nil, fmt.Errorf("failed open `%s`, %s", uri, gioError(gerr))
+	}
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(stream)))
+
+	var data bytes.Buffer
+	buf := C.malloc(gioChunk)
+	defer C.free(buf)
+
+	for {
+		n := C.g_input_stream_read((*C.GInputStream)(unsafe.Pointer(stream)), buf, gioChunk, nil, &gerr)
+		if n < 0 {
+			return nil, fmt.Errorf("failed read `%s`, %s", uri, gioError(gerr))
+		}
+		if n == 0 {
+			break
+		}
+		data.Write(C.GoBytes(buf, C.int(n)))
+	}
+
+	C.g_input_stream_close((*C.GInputStream)(unsafe.Pointer(stream)), nil, nil)
+	return data.Bytes(), nil
+}
+
+// gioWriteURI replace content of the location by GFile output stream,
+// GIO writes to temporary file and renames it when backend supports it
+func gioWriteURI(uri string, data []byte) error {
+	file := gioFile(uri)
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(file)))
+
+	var gerr *C.GError
+	stream := C.g_file_replace(file, nil, C.FALSE, C.G_FILE_CREATE_NONE, nil, &gerr)
+	if stream == nil {
+		return fmt.Errorf("failed open `%s` for writing, %s", uri, gioError(gerr))
+	}
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(stream)))
+
+	out := (*C.GOutputStream)(unsafe.Pointer(stream))
+
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > gioChunk {
+			chunk = chunk[:gioChunk]
+		}
+
+		cbuf := C.CBytes(chunk)
+		var written C.gsize
+		ok := C.g_output_stream_write_all(out, cbuf, C.gsize(len(chunk)), &written, nil, &gerr)
+		C.free(cbuf)
+		if ok == C.FALSE {
+			C.g_output_stream_close(out, nil, nil)
+			return fmt.Errorf("failed write `%s`, %s", uri, gioError(gerr))
+		}
+
+		data = data[len(chunk):]
+	}
+
+	//file is replaced only on successful close
+	if C.g_output_stream_close(out, nil, &gerr) == C.FALSE {
+		return fmt.Errorf("failed write `%s`, %s", uri, gioError(gerr))
+	}
+	return nil
+}
+
+// gioCanWrite check access of the location, true if backend does not report it
+func gioCanWrite(uri string) bool {
+	file := gioFile(uri)
+	defer C.g_object_unref(C.gpointer(unsafe.Pointer(file)))
+
+	return C.goatee_file_can_write(file) != C.FALSE
+}
+
+// WarnRemote tell that the location read through GIO is not watched for changes and has no
+// backups, they work only for local files
+func (t *Tab) WarnRemote() {
+	message := "Remote location: changes made by other programs are not detected"
+	if conf.Save.Backup {
+		message += ", backup is not created on save"
+	}
+	t.ShowInfoBar("remote", gtk.MESSAGE_INFO, message+".", []string{"OK"}, nil)
+}
+
+// FileMonitor GIO monitor of the local file, callback is called from the main loop
+type FileMonitor struct {
+	Filename string
+
+	id      uintptr
+	monitor *C.GFileMonitor
+}
+
+var fileMonitors = make(map[uintptr]func())
+var fileMonitorsID uintptr
+
+// gioMonitorFile call f on every finished change, removal or creation of the file,
+// GIO monitors the directory, so the file is watched after it is replaced by rename
+func gioMonitorFile(filename string, f func()) (*FileMonitor, error) {
+	cfilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cfilename))
+
+	fileMonitorsID++
+	m := &FileMonitor{Filename: filename, id: fileMonitorsID}
+	m.monitor = C.goatee_monitor_file(cfilename, C.guintptr(m.id))
+	if m.monitor == nil {
+		return nil, fmt.Errorf("failed monitor file `%s`", filename)
+	}
+
+	fileMonitors[m.id] = f
+	return m, nil
+}
+
+// Cancel stop monitoring, it is safe to call on nil monitor
+func (m *FileMonitor) Cancel() {
+	if m == nil || m.monitor == nil {
+		return
+	}
+
+	delete(fileMonitors, m.id)
+	C.g_file_monitor_cancel(m.monitor)
+	C.g_object_unref(C.gpointer(unsafe.Pointer(m.monitor)))
+	m.monitor = nil
+}
+
+func fileMonitorEvent(id uintptr, event C.GFileMonitorEvent) {
+	//file is written by several events, it is checked when writing is done
+	if event == C.G_FILE_MONITOR_EVENT_CHANGED || event == C.G_FILE_MONITOR_EVENT_PRE_UNMOUNT {
+		return
+	}
+
+	if f, ok := fileMonitors[id]; ok {
+		f()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsURI(t *testing.T) {
+	tests := []struct {
+		filename string
+		uri      bool
+	}{
+		{"/tmp/file.txt", false},
+		{"file.txt", false},
+		{"C:/file.txt", false},
+		{"notes:old.txt", false},
+		{"dir/a://b", false},
+		{"file:///tmp/file.txt", true},
+		{"sftp://host/file.txt", true},
+	}
+
+	for _, tt := range tests {
+		if uri := isURI(tt.filename); uri != tt.uri {
+			t.Errorf("isURI(%q) = %v, want %v", tt.filename, uri, tt.uri)
+		}
+	}
+}
+
+func TestGioFileURI(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	uri := (&url.URL{Scheme: "file", Path: filename}).String()
+
+	//content is longer than one chunk of the stream
+	data := bytes.Repeat([]byte("goatee\n"), gioChunk/7+100)
+	if err := gioWriteURI(uri, data); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(disk, data) {
+		t.Fatalf("written %d bytes, on disk %d bytes", len(data), len(disk))
+	}
+
+	read, err := gioReadURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("written %d bytes, read %d bytes", len(data), len(read))
+	}
+
+	//shorter content replaces the file completely
+	if err := gioWriteURI(uri, []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	read, err = gioReadURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(read) != "short" {
+		t.Fatalf("read %q after replace, want %q", read, "short")
+	}
+
+	if _, err := gioReadURI(uri + "-missing"); err == nil {
+		t.Fatal("reading of missing file does not fail")
+	}
+}
+
+func TestGioLocalPath(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	uri := (&url.URL{Scheme: "file", Path: filename}).String()
+	if local := gioLocalPath(uri); local != filename {
+		t.Errorf("gioLocalPath(%q) = %q, want %q", uri, local, filename)
+	}
+
+	uri = "sftp://host/file.txt"
+	if local := gioLocalPath(uri); len(local) > 0 {
+		t.Errorf("gioLocalPath(%q) = %q, want remote location", uri, local)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+	"golang.org/x/text/unicode/runenames"
+)
+
+// maxCharMatches limit of characters found by name in the insert dialog
+const maxCharMatches = 200
+
+const (
+	charColChar = iota
+	charColCode
+	charColName
+)
+
+// categoryNames general categories in the order they are checked
+var categoryNames = []struct {
+	cat  string
+	name string
+}{
+	{"Lu", "Letter, uppercase"},
+	{"Ll", "Letter, lowercase"},
+	{"Lt", "Letter, titlecase"},
+	{"Lm", "Letter, modifier"},
+	{"Lo", "Letter, other"},
+	{"Mn", "Mark, nonspacing"},
+	{"Mc", "Mark, spacing combining"},
+	{"Me", "Mark, enclosing"},
+	{"Nd", "Number, decimal digit"},
+	{"Nl", "Number, letter"},
+	{"No", "Number, other"},
+	{"Pc", "Punctuation, connector"},
+	{"Pd", "Punctuation, dash"},
+	{"Ps", "Punctuation, open"},
+	{"Pe", "Punctuation, close"},
+	{"Pi", "Punctuation, initial quote"},
+	{"Pf", "Punctuation, final quote"},
+	{"Po", "Punctuation, other"},
+	{"Sm", "Symbol, math"},
+	{"Sc", "Symbol, currency"},
+	{"Sk", "Symbol, modifier"},
+	{"So", "Symbol, other"},
+	{"Zs", "Separator, space"},
+	{"Zl", "Separator, line"},
+	{"Zp", "Separator, paragraph"},
+	{"Cc", "Other, control"},
+	{"Cf", "Other, format"},
+	{"Co", "Other, private use"},
+	{"Cs", "Other, surrogate"},
+}
+
+// Inspector panel with details of the character under the cursor
+type Inspector struct {
+	box   *gtk.HBox
+	label *gtk.Label
+
+	//converter to the encoding of the current tab, it is reused while encoding is the same
+	converter *Converter
+	charset   string
+}
+
+func NewInspector() *Inspector {
+	in := new(Inspector)
+
+	in.label = gtk.NewLabel("")
+	in.label.SetSelectable(true)
+	in.label.SetAlignment(0, 0.5)
+
+	insertBtn := gtk.NewButtonWithLabel("Insert...")
+	insertBtn.SetRelief(gtk.RELIEF_NONE)
+	insertBtn.SetTooltipText("Insert character by name or code point")
+	insertBtn.Clicked(func() { ui.InsertCharacter() })
+
+	in.box = gtk.NewHBox(false, 0)
+	in.box.PackStart(in.label, true, true, 5)
+	in.box.PackEnd(insertBtn, false, false, 0)
+
+	return in
+}
+
+// Update show the character at the cursor of the tab
+func (in *Inspector) Update(t *Tab) {
+	if !in.box.GetVisible() || t == nil || t.sourcebuffer == nil {
+		return
+	}
+
+	var start, end gtk.TextIter
+	t.sourcebuffer.GetIterAtMark(&start, t.sourcebuffer.GetInsert())
+	end = start
+	if !end.ForwardChar() {
+		in.label.SetText("end of text")
+		return
+	}
+
+	text := t.sourcebuffer.GetText(&start, &end, true)
+	r, _ := utf8.DecodeRuneInString(text)
+
+	info := []string{
+		fmt.Sprintf("U+%04X", r),
+		runeName(r),
+		runeCategory(r),
+		"UTF-8: " + hexBytes([]byte(text)),
+	}
+	if enc := in.encode(text, t.Encoding); len(enc) > 0 {
+		info = append(info, enc)
+	}
+	in.label.SetText(strings.Join(info, "    "))
+}
+
+// encode returns bytes of the character in the charset of the tab
+func (in *Inspector) encode(text, charset string) string {
+	switch charset {
+	case "", CHARSET_UTF8, CHARSET_ASCII, CHARSET_BINARY:
+		return ""
+	}
+
+	if in.converter == nil || in.charset != charset {
+		if in.converter != nil {
+			in.converter.Close()
+			in.converter = nil
+		}
+
+		converter, err := NewConverter(CHARSET_UTF8, charset)
+		if err != nil {
+			log.Println(err)
+			return ""
+		}
+		in.converter, in.charset = converter, charset
+
+		//characters are parts of one stream, so BOM of UTF-16/32 is written once here
+		in.converter.Reset()
+		in.converter.ConvertPart(ioutil.Discard, strings.NewReader("a"))
+	}
+
+	var data bytes.Buffer
+	err := in.converter.ConvertPart(&data, strings.NewReader(text))
+
+	//stateful charset returns to ascii, so every character is shown with its shift sequence
+	in.converter.ConvertPart(ioutil.Discard, strings.NewReader("a"))
+
+	if err != nil {
+		return charset + ": not representable"
+	}
+	return charset + ": " + hexBytes(data.Bytes())
+}
+
+func hexBytes(data []byte) string {
+	var res []string
+	for _, b := range data {
+		res = append(res, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(res, " ")
+}
+
+func runeName(r rune) string {
+	if name := runenames.Name(r); len(name) > 0 {
+		return name
+	}
+	return "<unnamed>"
+}
+
+// runeCategory returns general category of the character, ex: `Lu (Letter, uppercase)`
+func runeCategory(r rune) string {
+	for _, c := range categoryNames {
+		if table, ok := unicode.Categories[c.cat]; ok && unicode.Is(table, r) {
+			return c.cat + " (" + c.name + ")"
+		}
+	}
+	return "Cn (Other, not assigned)"
+}
+
+// ToggleInspector show or hide the character inspector
+func (ui *UI) ToggleInspector() {
+	visible := ui.inspectorAction.GetActive()
+	ui.inspector.box.SetVisible(visible)
+	if visible {
+		ui.inspector.box.ShowAll()
+		ui.inspector.Update(ui.GetCurrentTab())
+	}
+}
+
+// InsertCharacter insert character chosen by name or hex code point at the cursor
+func (ui *UI) InsertCharacter() {
+	t := ui.GetCurrentTab()
+	//read only page of the large file is not editable too
+	if t == nil || t.sourcebuffer == nil || t.ReadOnly || !t.sourceview.GetEditable() {
+		return
+	}
+
+	r, ok := dialogCharacter()
+	if !ok {
+		return
+	}
+	t.sourcebuffer.InsertInteractiveAtCursor(string(r), t.sourceview.GetEditable())
+	t.sourceview.GrabFocus()
+}
+
+// parseCodePoint parse code point written as `U+00E9`, `0xE9` or `E9`
+func parseCodePoint(s string) (rune, bool) {
+	s = strings.TrimSpace(s)
+	for _, prefix := range []string{"U+", "u+", "0x", "0X", `\u`, `\U`} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || n > unicode.MaxRune || !insertable(rune(n)) {
+		return 0, false
+	}
+	return rune(n), true
+}
+
+// insertable returns false for NUL, surrogates and non-characters, text buffer can not keep them
+func insertable(r rune) bool {
+	switch {
+	case r == 0, r >= 0xD800 && r <= 0xDFFF, r >= 0xFDD0 && r <= 0xFDEF, r&0xFFFE == 0xFFFE:
+		return false
+	}
+	return true
+}
+
+// namedRune character with its name for the search by name
+type namedRune struct {
+	r    rune
+	name string
+}
+
+// runeNamesIndex names of all named characters, it is built by the first search
+var runeNamesIndex []namedRune
+
+func runeNames() []namedRune {
+	if runeNamesIndex != nil {
+		return runeNamesIndex
+	}
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		if name := runenames.Name(r); len(name) > 0 && !strings.HasPrefix(name, "<") {
+			runeNamesIndex = append(runeNamesIndex, namedRune{r, name})
+		}
+	}
+	return runeNamesIndex
+}
+
+// findRunes returns characters which names contain all words of the query, the name equal to
+// the query goes first, then shorter names as more exact matches
+func findRunes(query string) []rune {
+	words := strings.Fields(strings.ToUpper(query))
+	if len(words) == 0 {
+		return nil
+	}
+	exact := strings.Join(words, " ")
+
+	var found []namedRune
+	for _, nr := range runeNames() {
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(nr.name, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found = append(found, nr)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if ei, ej := found[i].name == exact, found[j].name == exact; ei != ej {
+			return ei
+		}
+		return len(found[i].name) < len(found[j].name)
+	})
+
+	if len(found) > maxCharMatches {
+		found = found[:maxCharMatches]
+	}
+	res := make([]rune, len(found))
+	for i, nr := range found {
+		res[i] = nr.r
+	}
+	return res
+}
+
+// dialogCharacter returns character found by name or code point
+func dialogCharacter() (rune, bool) {
+	dialog := gtk.NewDialog()
+	dialog.SetTitle("Insert Character")
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+	dialog.SetDefaultSize(450, 350)
+
+	entry := gtk.NewEntry()
+	entry.SetTooltipText("Name of character, ex: `greek small alpha`, or code point, ex: `U+03B1`")
+
+	store := gtk.NewListStore(glib.G_TYPE_STRING, glib.G_TYPE_STRING, glib.G_TYPE_STRING)
+	view := gtk.NewTreeView()
+	view.SetModel(store)
+	view.SetHeadersVisible(false)
+	view.AppendColumn(gtk.NewTreeViewColumnWithAttributes("", gtk.NewCellRendererText(), "text", charColChar))
+	view.AppendColumn(gtk.NewTreeViewColumnWithAttributes("", gtk.NewCellRendererText(), "text", charColCode))
+	view.AppendColumn(gtk.NewTreeViewColumnWithAttributes("", gtk.NewCellRendererText(), "text", charColName))
+
+	swin := gtk.NewScrolledWindow(nil, nil)
+	swin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	swin.SetShadowType(gtk.SHADOW_IN)
+	swin.Add(view)
+
+	search := func() {
+		store.Clear()
+		query := entry.GetText()
+
+		var found []rune
+		if r, ok := parseCodePoint(query); ok {
+			found = append(found, r)
+		}
+		if len(strings.TrimSpace(query)) > 2 {
+			found = append(found, findRunes(query)...)
+		}
+
+		for _, r := range found {
+			var iter gtk.TreeIter
+			store.Append(&iter)
+			store.Set(&iter, string(r), fmt.Sprintf("U+%04X", r), runeName(r))
+		}
+
+		if len(found) > 0 {
+			var iter gtk.TreeIter
+			store.GetIterFirst(&iter)
+			view.GetSelection().SelectIter(&iter)
+		}
+	}
+
+	//searching by name walks all named characters, so it waits for pause in typing,
+	//only the last change runs the search
+	var changes int
+	searched := true
+	entry.Connect("changed", func() {
+		changes++
+		searched = false
+		n := changes
+		glib.TimeoutAdd(300, func() bool {
+			if n == changes && !searched {
+				searched = true
+				search()
+			}
+			return false
+		})
+	})
+	entry.Connect("activate", func() {
+		if !searched {
+			searched = true
+			search()
+		}
+		dialog.Response(gtk.RESPONSE_OK)
+	})
+	view.Connect("row-activated", func() { dialog.Response(gtk.RESPONSE_OK) })
+
+	vbox := dialog.GetVBox()
+	vbox.PackStart(entry, false, false, 5)
+	vbox.PackStart(swin, true, true, 0)
+
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton("Insert", gtk.RESPONSE_OK)
+	dialog.SetDefaultResponse(gtk.RESPONSE_OK)
+	dialog.ShowAll()
+
+	response := dialog.Run()
+	searched = true
+
+	var res rune
+	ok := false
+	if response == gtk.RESPONSE_OK {
+		var iter gtk.TreeIter
+		if view.GetSelection().GetSelected(&iter) {
+			var val glib.GValue
+			store.GetValue(&iter, charColChar, &val)
+			res, _ = utf8.DecodeRuneInString(val.GetString())
+			ok = true
+		}
+	}
+	dialog.Destroy()
+	return res, ok
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/gtk"
+)
+
+// LargeFile shows big file by pages, only the current page is loaded to the buffer,
+// edited pages are kept in memory until save
+type LargeFile struct {
+	filename string
+	size     int64
+	modTime  time.Time
+	pagesize int64
+
+	page     int64
+	edits    map[int64][]byte
+	editable bool
+
+	nav       *gtk.HBox
+	pageLabel *gtk.Label
+}
+
+// isLarge check size of the file, compressed files can not be paged and always read whole
+func (t *Tab) isLarge(stat os.FileInfo) bool {
+	return conf.LargeFile.Threshold > 0 && stat.Size() > int64(conf.LargeFile.Threshold)<<20 &&
+		!isCompressedFile(t.Filename)
+}
+
+// OpenLarge open file in the large file mode and show first page
+func (t *Tab) OpenLarge(filename string, stat os.FileInfo) error {
+	pagesize := int64(conf.LargeFile.PageSize) << 10
+	if pagesize <= 0 {
+		pagesize = 1 << 20
+	}
+
+	l := &LargeFile{
+		filename: filename,
+		size:     stat.Size(),
+		modTime:  stat.ModTime(),
+		pagesize: pagesize,
+		edits:    make(map[int64][]byte),
+	}
+
+	data, err := l.readPage(0)
+	if err != nil {
+		return err
+	}
+
+	//pages are split by bytes of utf-8, files in other charsets can not be paged
+	if encoding := t.largeEncoding(filename, data); len(encoding) > 0 {
+		return fmt.Errorf("file `%s` is in %s, large files are opened only in UTF-8, "+
+			"increase the large file threshold in preferences to open it whole", filename, encoding)
+	}
+
+	t.large = l
+	t.Encoding = CHARSET_UTF8
+	t.LineEnding, _ = DetectLineEnding(data)
+	t.createLargeNav()
+
+	t.Language = t.DetectLanguage(data)
+
+	t.showPage(0, data)
+	return nil
+}
+
+// largeEncoding returns charset of the file detected by the first page if it is not utf-8,
+// binary files are shown as utf-8 with read only pages
+func (t *Tab) largeEncoding(filename string, data []byte) string {
+	encoding := ui.metadata.Encoding(filename)
+	if _, bomCharset := stripBOM(data); len(bomCharset) > 0 {
+		encoding = bomCharset
+	} else if charset, _ := t.editorconfig.Charset(); len(encoding) == 0 && len(charset) > 0 {
+		encoding = charset
+	} else if len(encoding) == 0 {
+		encoding, _ = t.DetectEncoding(data)
+	}
+
+	switch {
+	case len(encoding) == 0, encoding == CHARSET_BINARY,
+		strings.EqualFold(encoding, CHARSET_UTF8), strings.EqualFold(encoding, CHARSET_ASCII):
+		return ""
+	}
+	return encoding
+}
+
+func (t *Tab) createLargeNav() {
+	l := t.large
+
+	prevBtn := gtk.NewButton()
+	prevBtn.Add(gtk.NewArrow(gtk.ARROW_LEFT, gtk.SHADOW_NONE))
+	prevBtn.Clicked(func() { t.GotoPage(l.page - 1) })
+
+	nextBtn := gtk.NewButton()
+	nextBtn.Add(gtk.NewArrow(gtk.ARROW_RIGHT, gtk.SHADOW_NONE))
+	nextBtn.Clicked(func() { t.GotoPage(l.page + 1) })
+
+	findBtn := gtk.NewButtonWithLabel("Find in file")
+	findBtn.Clicked(t.FindInLarge)
+
+	l.pageLabel = gtk.NewLabel("")
+
+	l.nav = gtk.NewHBox(false, 0)
+	l.nav.PackStart(prevBtn, false, false, 0)
+	l.nav.PackStart(nextBtn, false, false, 0)
+	l.nav.PackStart(l.pageLabel, true, true, 5)
+	l.nav.PackEnd(findBtn, false, false, 0)
+
+	t.page.PackStart(l.nav, false, false, 0)
+}
+
+func (l *LargeFile) pages() int64 {
+	return (l.size + l.pagesize - 1) / l.pagesize
+}
+
+// boundary returns offset of page start, page starts after the first line break following k*pagesize
+func (l *LargeFile) boundary(k int64) (int64, error) {
+	if k <= 0 {
+		return 0, nil
+	}
+
+	offset := k * l.pagesize
+	if offset >= l.size {
+		return l.size, nil
+	}
+
+	f, err := os.Open(l.filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 64<<10)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return offset + int64(i) + 1, nil
+	}
+
+	//very long line, keep at least whole utf-8 characters
+	for i := 0; i < len(buf) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(buf[i]) {
+			return offset + int64(i), nil
+		}
+	}
+	return offset, nil
+}
+
+func (l *LargeFile) readPage(k int64) ([]byte, error) {
+	if data, ok := l.edits[k]; ok {
+		return data, nil
+	}
+
+	start, err := l.boundary(k)
+	if err != nil {
+		return nil, err
+	}
+	end, err := l.boundary(k + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(l.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, end-start)
+	if _, err := f.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed read file `%s`, %s", l.filename, err)
+	}
+	return data, nil
+}
+
+// storePage keep edited text of the current page
+func (t *Tab) storePage() {
+	if t.sourcebuffer.GetModified() && t.large.editable {
+		t.large.edits[t.large.page] = []byte(t.GetText(true))
+	}
+}
+
+func (t *Tab) GotoPage(k int64) {
+	l := t.large
+	if k < 0 || k >= l.pages() || k == l.page {
+		return
+	}
+
+	t.storePage()
+
+	data, err := l.readPage(k)
+	if err != nil {
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	t.showPage(k, data)
+}
+
+func (t *Tab) showPage(k int64, data []byte) {
+	l := t.large
+	l.page = k
+
+	//pages with broken utf-8 are shown, but can not be edited
+	l.editable = utf8.Valid(data)
+	text := string(data)
+	if !l.editable {
+		text = string(bytes.ToValidUTF8(data, []byte("�")))
+	}
+
+	//the file is still modified if other pages are edited
+	t.sourcebuffer.BeginNotUndoableAction()
+	t.sourcebuffer.SetText(text)
+	t.sourcebuffer.EndNotUndoableAction()
+	t.SetSavePoint()
+
+	t.sourceview.SetEditable(l.editable && !t.ReadOnly)
+	l.pageLabel.SetText(l.status())
+}
+
+// status returns number of the current page and its state
+func (l *LargeFile) status() string {
+	status := fmt.Sprintf("page %d / %d", l.page+1, l.pages())
+	if !l.editable {
+		status += " (read only: invalid utf-8)"
+	}
+	if _, ok := l.edits[l.page]; ok {
+		status += " (modified)"
+	}
+	return status
+}
+
+// FindInLarge search text of the find bar in the whole file after the cursor, search wraps
+// to the beginning of the file, match may continue on the next page
+func (t *Tab) FindInLarge() {
+	l := t.large
+
+	query := ui.footer.findEntry.GetText()
+	if len(query) == 0 {
+		ui.footer.ShowFindbar()
+		return
+	}
+
+	flags := "ms"
+	if !ui.footer.caseBtn.GetActive() {
+		flags += "i"
+	}
+	if !ui.footer.regBtn.GetActive() {
+		query = regexp.QuoteMeta(query)
+	}
+
+	reg, err := regexp.Compile(fmt.Sprintf("(?%s)%s", flags, query))
+	if err != nil {
+		log.Println("invalid search query,", err)
+		return
+	}
+
+	t.storePage()
+
+	current, err := l.readPage(l.page)
+	if err != nil {
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	//start after the character at the cursor, offset in the buffer is converted to bytes of the page
+	var iter gtk.TextIter
+	t.sourcebuffer.GetIterAtMark(&iter, t.sourcebuffer.GetInsert())
+	cursor := pageByteOffset(current, iter.GetOffset())
+	if cursor < len(current) {
+		_, size := utf8.DecodeRune(current[cursor:])
+		cursor += size
+	}
+
+	//the current page is searched twice: after the cursor and, after wrap, before it
+	first := l.page
+	for i := int64(0); i <= l.pages(); i++ {
+		k := (first + i) % l.pages()
+
+		data, err := l.readPage(k)
+		if err != nil {
+			errorMessage(err)
+			log.Println(err)
+			return
+		}
+
+		from := 0
+		if i == 0 {
+			from = cursor
+		}
+
+		start, end, ok, err := l.findInPage(reg, k, data, from)
+		if err != nil {
+			errorMessage(err)
+			log.Println(err)
+			return
+		}
+		if !ok {
+			continue
+		}
+
+		if k != l.page {
+			t.showPage(k, data)
+		}
+
+		var startIter, endIter gtk.TextIter
+		t.sourcebuffer.GetIterAtOffset(&startIter, pageCharOffset(data, start))
+		t.sourcebuffer.GetIterAtOffset(&endIter, pageCharOffset(data, end))
+		t.sourcebuffer.SelectRange(&startIter, &endIter)
+		t.Scroll(startIter)
+
+		if i > 0 && k <= first {
+			l.pageLabel.SetText(l.status() + ", search wrapped")
+		} else {
+			l.pageLabel.SetText(l.status())
+		}
+		return
+	}
+
+	l.pageLabel.SetText(fmt.Sprintf("%s, `%s` not found", l.status(), ui.footer.findEntry.GetText()))
+}
+
+// largeFindOverlap bytes of the next page searched together with the page, so text that
+// crosses the page boundary is found
+const largeFindOverlap = 4 << 10
+
+// findInPage returns byte offsets of the first match starting in the page after from, match
+// continued on the next page is cut by the end of the page
+func (l *LargeFile) findInPage(reg *regexp.Regexp, k int64, data []byte, from int) (int, int, bool, error) {
+	if from > len(data) {
+		return 0, 0, false, nil
+	}
+
+	text := data
+	if k+1 < l.pages() {
+		next, err := l.readPage(k + 1)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if len(next) > largeFindOverlap {
+			next = next[:largeFindOverlap]
+		}
+		text = append(append([]byte{}, data...), next...)
+	}
+
+	loc := reg.FindIndex(text[from:])
+	if loc == nil || from+loc[0] >= len(data) {
+		return 0, 0, false, nil
+	}
+
+	start, end := from+loc[0], from+loc[1]
+	if end > len(data) {
+		end = len(data)
+	}
+	return start, end, true, nil
+}
+
+// pageCharOffset returns offset in the buffer for the byte offset of the page, sequence of
+// invalid bytes is shown as one replacement character
+func pageCharOffset(data []byte, n int) int {
+	return utf8.RuneCount(bytes.ToValidUTF8(data[:n], []byte("\uFFFD")))
+}
+
+// pageByteOffset returns byte offset of the page for the offset in the buffer, it is the
+// reverse of pageCharOffset
+func pageByteOffset(data []byte, chars int) int {
+	var i int
+	for ; chars > 0 && i < len(data); chars-- {
+		if r, size := utf8.DecodeRune(data[i:]); r != utf8.RuneError || size > 1 {
+			i += size
+			continue
+		}
+
+		for i < len(data) {
+			if r, size := utf8.DecodeRune(data[i:]); r != utf8.RuneError || size > 1 {
+				break
+			}
+			i++
+		}
+	}
+	return i
+}
+
+// ConfirmLargeChanged ask before saving if the file is changed on disk after it was read, not
+// edited pages are copied from the changed file, so edited pages would be placed at shifted
+// offsets of the new content. Returns false if saving is canceled
+func (t *Tab) ConfirmLargeChanged() bool {
+	l := t.large
+	stat, err := os.Stat(l.filename)
+	if err != nil || (stat.Size() == l.size && stat.ModTime().Equal(l.modTime)) {
+		return true
+	}
+
+	dialog := gtk.NewDialog()
+	dialog.SetTitle("File changed on disk")
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+
+	message := fmt.Sprintf("File `%s` has been changed on disk after it was opened. Edited pages will "+
+		"be placed between parts of the new content, they may be at wrong positions.", l.filename)
+	label := gtk.NewLabel(message)
+	label.SetLineWrap(true)
+	dialog.GetVBox().PackStart(label, false, false, 5)
+
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton("Save Anyway", gtk.RESPONSE_ACCEPT)
+	dialog.SetDefaultResponse(gtk.RESPONSE_CANCEL)
+	dialog.ShowAll()
+
+	response := dialog.Run()
+	dialog.Destroy()
+
+	return response == gtk.RESPONSE_ACCEPT
+}
+
+// SaveLarge write file with edited pages, not edited parts are copied from the original file
+func (t *Tab) SaveLarge() error {
+	l := t.large
+	t.storePage()
+
+	src, err := os.Open(l.filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	err = saveFileStream(t.Filename, func(w io.Writer) error {
+		for k := int64(0); k < l.pages(); k++ {
+			if data, ok := l.edits[k]; ok {
+				if _, err := w.Write(data); err != nil {
+					return err
+				}
+				continue
+			}
+
+			start, err := l.boundary(k)
+			if err != nil {
+				return err
+			}
+			end, err := l.boundary(k + 1)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(w, io.NewSectionReader(src, start, end-start)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	//file is replaced, so page boundaries must be counted again
+	stat, err := os.Stat(t.Filename)
+	if err != nil {
+		return err
+	}
+
+	l.filename = t.Filename
+	l.size = stat.Size()
+	l.modTime = stat.ModTime()
+	l.edits = make(map[int64][]byte)
+
+	return t.reloadPage()
+}
+
+// ReloadLarge forget edits and show the current page of the file on disk
+func (t *Tab) ReloadLarge() error {
+	stat, err := os.Stat(t.large.filename)
+	if err != nil {
+		return err
+	}
+
+	t.large.size = stat.Size()
+	t.large.modTime = stat.ModTime()
+	t.large.edits = make(map[int64][]byte)
+
+	return t.reloadPage()
+}
+
+func (t *Tab) reloadPage() error {
+	l := t.large
+	if l.page >= l.pages() {
+		l.page = 0
+	}
+
+	var iter gtk.TextIter
+	t.sourcebuffer.GetIterAtMark(&iter, t.sourcebuffer.GetInsert())
+	cursor := iter.GetOffset()
+
+	data, err := l.readPage(l.page)
+	if err != nil {
+		return err
+	}
+	t.showPage(l.page, data)
+
+	t.sourcebuffer.GetIterAtOffset(&iter, cursor)
+	t.sourcebuffer.PlaceCursor(&iter)
+
+	return nil
+}
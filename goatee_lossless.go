@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/gtk"
+)
+
+// substituteChar replaces characters which can not be saved in the encoding of the file
+const substituteChar = "?"
+
+// CheckEncodable check that the text round-trips through the encoding of the file, unrepresentable
+// characters are highlighted and user chooses to switch to UTF-8, substitute them or cancel saving
+func (t *Tab) CheckEncodable() bool {
+	if t.large != nil || t.Encoding == CHARSET_BINARY || t.Encoding == CHARSET_UTF8 {
+		return true
+	}
+
+	t.clearUnencodable()
+
+	text := t.GetText(true)
+	if t.roundTrips(text) {
+		return true
+	}
+
+	offsets := t.unencodableOffsets(text)
+	if len(offsets) == 0 {
+		return true
+	}
+
+	if t.tagUnencodable == nil {
+		t.tagUnencodable = t.sourcebuffer.CreateTag("unencodable", map[string]interface{}{"background": "#ff6666"})
+	}
+
+	var start, end gtk.TextIter
+	for _, offset := range offsets {
+		t.sourcebuffer.GetIterAtOffset(&start, offset)
+		t.sourcebuffer.GetIterAtOffset(&end, offset+1)
+		t.sourcebuffer.ApplyTag(t.tagUnencodable, &start, &end)
+	}
+	t.sourcebuffer.GetIterAtOffset(&start, offsets[0])
+	t.Scroll(start)
+
+	dialog := gtk.NewDialog()
+	dialog.SetTitle("Unrepresentable characters")
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+
+	message := fmt.Sprintf("%d characters of `%s` can not be saved in %s, they are highlighted in the text.",
+		len(offsets), t.label.GetText(), t.Encoding)
+	label := gtk.NewLabel(message)
+	label.SetLineWrap(true)
+	dialog.GetVBox().PackStart(label, false, false, 5)
+
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton("Substitute with `"+substituteChar+"`", gtk.RESPONSE_APPLY)
+	dialog.AddButton("Switch to UTF-8", gtk.RESPONSE_ACCEPT)
+	dialog.SetDefaultResponse(gtk.RESPONSE_ACCEPT)
+	dialog.ShowAll()
+
+	response := dialog.Run()
+	dialog.Destroy()
+
+	switch response {
+	case gtk.RESPONSE_ACCEPT:
+		//byte order mark belonged to the previous charset
+		t.clearUnencodable()
+		t.Encoding = CHARSET_UTF8
+		t.BOM = false
+		t.UpdateMenuSeleted()
+		//next opening of the file uses the new encoding instead of the chosen before
+		ui.metadata.SetEncoding(t.Filename, t.Encoding)
+		return true
+	case gtk.RESPONSE_APPLY:
+		t.clearUnencodable()
+		t.substitute(offsets)
+		return true
+	}
+	return false
+}
+
+// roundTrips returns true if text is the same after conversion to the encoding and back
+func (t *Tab) roundTrips(text string) bool {
+	data, err := t.ChangeEncoding([]byte(text), t.Encoding, CHARSET_UTF8)
+	if err != nil {
+		return false
+	}
+	back, err := t.ChangeEncoding(data, CHARSET_UTF8, t.Encoding)
+	return err == nil && string(back) == text
+}
+
+// unencodableOffsets returns character offsets of runes which do not round-trip, every distinct
+// rune is checked once
+func (t *Tab) unencodableOffsets(text string) []int {
+	encodable := make(map[rune]bool)
+
+	var offsets []int
+	var offset int
+	for _, r := range text {
+		if r >= utf8.RuneSelf {
+			ok, checked := encodable[r]
+			if !checked {
+				ok = t.roundTrips(string(r))
+				encodable[r] = ok
+			}
+			if !ok {
+				offsets = append(offsets, offset)
+			}
+		}
+		offset++
+	}
+	return offsets
+}
+
+// substitute replace characters at offsets by substituteChar, it is one undo step
+func (t *Tab) substitute(offsets []int) {
+	beginUserAction(t.sourcebuffer)
+	defer endUserAction(t.sourcebuffer)
+
+	var start, end gtk.TextIter
+	for i := len(offsets) - 1; i >= 0; i-- {
+		t.sourcebuffer.GetIterAtOffset(&start, offsets[i])
+		t.sourcebuffer.GetIterAtOffset(&end, offsets[i]+1)
+		t.sourcebuffer.Delete(&start, &end)
+		t.sourcebuffer.GetIterAtOffset(&start, offsets[i])
+		t.sourcebuffer.Insert(&start, substituteChar)
+	}
+}
+
+func (t *Tab) clearUnencodable() {
+	if t.tagUnencodable == nil {
+		return
+	}
+
+	var start, end gtk.TextIter
+	t.sourcebuffer.GetStartIter(&start)
+	t.sourcebuffer.GetEndIter(&end)
+	t.sourcebuffer.RemoveTag(t.tagUnencodable, &start, &end)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells limit memory used by LCS table, bigger changes are treated as one conflict
+const maxDiffCells = 16 * 1024 * 1024
+
+// merge3 line based three-way merge, returns merged text and count of conflicts
+func merge3(base, mine, theirs string) (string, int) {
+	b := splitLines(base)
+	a := splitLines(mine)
+	c := splitLines(theirs)
+
+	ma := matchLines(b, a)
+	mc := matchLines(b, c)
+
+	var out []string
+	var conflicts int
+
+	var i, ia, ic int
+	for {
+		//next line of base that is kept unchanged in both versions
+		j := i
+		for j < len(b) && (ma[j] < ia || mc[j] < ic) {
+			j++
+		}
+
+		ea, ec := len(a), len(c)
+		if j < len(b) {
+			ea, ec = ma[j], mc[j]
+		}
+
+		chunkBase, chunkA, chunkC := b[i:j], a[ia:ea], c[ic:ec]
+		switch {
+		case equalLines(chunkA, chunkBase):
+			out = append(out, chunkC...)
+		case equalLines(chunkC, chunkBase), equalLines(chunkA, chunkC):
+			out = append(out, chunkA...)
+		default:
+			conflicts++
+			out = append(out, "<<<<<<< mine\n")
+			out = append(out, terminateLines(chunkA)...)
+			out = append(out, "=======\n")
+			out = append(out, terminateLines(chunkC)...)
+			out = append(out, ">>>>>>> disk\n")
+		}
+
+		if j >= len(b) {
+			break
+		}
+
+		out = append(out, b[j])
+		i, ia, ic = j+1, ea+1, ec+1
+	}
+
+	return strings.Join(out, ""), conflicts
+}
+
+// splitLines split text to lines, each line keep its line break
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func terminateLines(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	res := append([]string{}, lines...)
+	res[len(res)-1] += "\n"
+	return res
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matchLines for each line of a returns index of the matched line in b or -1, based on longest common subsequence
+func matchLines(a, b []string) []int {
+	m := make([]int, len(a))
+	for i := range m {
+		m[i] = -1
+	}
+
+	//common prefix and suffix do not need LCS table
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		m[start] = start
+		start++
+	}
+
+	ea, eb := len(a), len(b)
+	for ea > start && eb > start && a[ea-1] == b[eb-1] {
+		ea--
+		eb--
+		m[ea] = eb
+	}
+
+	n, k := ea-start, eb-start
+	if n == 0 || k == 0 || n*k > maxDiffCells {
+		return m
+	}
+
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, k+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := k - 1; j >= 0; j-- {
+			if a[start+i] == b[start+j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	for i, j := 0, 0; i < n && j < k; {
+		switch {
+		case a[start+i] == b[start+j]:
+			m[start+i] = start + j
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+
+	return m
+}
+
+// unifiedDiff returns differences of two texts in unified format with 3 lines of context
+func unifiedDiff(a, b, nameA, nameB string) string {
+	la := splitLines(a)
+	lb := splitLines(b)
+	m := matchLines(la, lb)
+
+	type line struct {
+		op   byte
+		text string
+	}
+
+	//full list of lines with operations
+	var lines []line
+	j := 0
+	for i := range la {
+		if m[i] < 0 {
+			lines = append(lines, line{'-', la[i]})
+			continue
+		}
+		for ; j < m[i]; j++ {
+			lines = append(lines, line{'+', lb[j]})
+		}
+		lines = append(lines, line{' ', la[i]})
+		j++
+	}
+	for ; j < len(lb); j++ {
+		lines = append(lines, line{'+', lb[j]})
+	}
+
+	const context = 3
+
+	var out []string
+	out = append(out, "--- "+nameA+"\n", "+++ "+nameB+"\n")
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+
+		//hunk starts before the change and ends when unchanged lines exceed context
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(lines) && lines[n].op == ' ' {
+				n++
+			}
+			if n == len(lines) || n-end > 2*context {
+				break
+			}
+			end = n
+		}
+		stop := end + context
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		var posA, posB, countA, countB int
+		for _, l := range lines[:start] {
+			if l.op != '+' {
+				posA++
+			}
+			if l.op != '-' {
+				posB++
+			}
+		}
+		for _, l := range lines[start:stop] {
+			if l.op != '+' {
+				countA++
+			}
+			if l.op != '-' {
+				countB++
+			}
+		}
+
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", posA+1, countA, posB+1, countB))
+		for _, l := range lines[start:stop] {
+			out = append(out, string(l.op)+strings.TrimSuffix(l.text, "\n")+"\n")
+		}
+
+		i = stop
+	}
+
+	return strings.Join(out, "")
+}
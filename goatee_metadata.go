@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+
+	"github.com/naoina/toml"
+)
+
+// Metadata encoding and language chosen by user for files, they override detection
+// when the file is opened again
+type Metadata struct {
+	Files []FileMetadata `toml:"files"`
+}
+
+type FileMetadata struct {
+	Filename string `toml:"filename"`
+	Encoding string `toml:"encoding,omitempty"`
+	Language string `toml:"language,omitempty"`
+}
+
+func metadataFilename() string {
+	return path.Join(stateDir(), "metadata.toml")
+}
+
+// LoadMetadata read overrides of files, files which no longer exist are pruned
+func LoadMetadata() *Metadata {
+	m := new(Metadata)
+
+	data, err := ioutil.ReadFile(metadataFilename())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return m
+	}
+
+	if err := toml.Unmarshal(data, m); err != nil {
+		log.Printf("failed decode metadata `%s`, %s", metadataFilename(), err)
+	}
+
+	m.prune()
+	return m
+}
+
+func (m *Metadata) Write() {
+	data, err := toml.Marshal(*m)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	os.MkdirAll(stateDir(), 0700)
+	if err := writeFileAtomic(metadataFilename(), data); err != nil {
+		log.Println("failed write metadata,", err)
+	}
+}
+
+// prune remove files which no longer exist and entries without overrides
+func (m *Metadata) prune() {
+	var files []FileMetadata
+	for _, f := range m.Files {
+		//remote locations are not checked, it may be slow
+		if _, err := os.Stat(f.Filename); err != nil && !isURI(f.Filename) {
+			continue
+		}
+		if len(f.Encoding) == 0 && len(f.Language) == 0 {
+			continue
+		}
+		files = append(files, f)
+	}
+	m.Files = files
+}
+
+func (m *Metadata) lookup(filename string) *FileMetadata {
+	if m == nil || len(filename) == 0 {
+		return nil
+	}
+
+	filename = absPath(filename)
+	for i := range m.Files {
+		if m.Files[i].Filename == filename {
+			return &m.Files[i]
+		}
+	}
+	return nil
+}
+
+// Encoding returns encoding chosen by user for the file, empty if it is not set
+func (m *Metadata) Encoding(filename string) string {
+	if f := m.lookup(filename); f != nil {
+		return f.Encoding
+	}
+	return ""
+}
+
+// Language returns language chosen by user for the file, empty if it is not set
+func (m *Metadata) Language(filename string) string {
+	if f := m.lookup(filename); f != nil {
+		return f.Language
+	}
+	return ""
+}
+
+func (m *Metadata) SetEncoding(filename, encoding string) {
+	m.update(filename, func(f *FileMetadata) { f.Encoding = encoding })
+}
+
+func (m *Metadata) SetLanguage(filename, lang string) {
+	m.update(filename, func(f *FileMetadata) { f.Language = lang })
+}
+
+func (m *Metadata) update(filename string, f func(*FileMetadata)) {
+	if m == nil || len(filename) == 0 {
+		return
+	}
+
+	fm := m.lookup(filename)
+	if fm == nil {
+		m.Files = append(m.Files, FileMetadata{Filename: absPath(filename)})
+		fm = &m.Files[len(m.Files)-1]
+	}
+	f(fm)
+
+	m.Write()
+}
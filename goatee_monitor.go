@@ -0,0 +1,294 @@
+package main
+
+/*
+#cgo pkg-config: gio-2.0
+#include <gio/gio.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+)
+
+//export goateeFileChanged
+func goateeFileChanged(id C.guintptr, event C.GFileMonitorEvent) {
+	fileMonitorEvent(uintptr(id), event)
+}
+
+// UpdateDiskState remember state of the file on disk and text that corresponds to it,
+// the file is monitored for changes made by other programs
+func (t *Tab) UpdateDiskState(text string) {
+	if len(t.Filename) == 0 || isURI(t.Filename) {
+		return
+	}
+
+	stat, err := os.Stat(t.Filename)
+	if err != nil {
+		t.diskStat = nil
+		return
+	}
+
+	t.diskStat = stat
+	t.diskText = text
+
+	if t.monitor == nil || t.monitor.Filename != t.Filename {
+		t.monitor.Cancel()
+		t.monitor, err = gioMonitorFile(t.Filename, t.CheckDiskChanges)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// CheckDiskChanges compare file on disk with the remembered state and ask user what to do
+func (t *Tab) CheckDiskChanges() {
+	if _, ok := t.infobars["disk"]; t.diskStat == nil || ok {
+		return
+	}
+
+	stat, err := os.Stat(t.Filename)
+	if os.IsNotExist(err) {
+		t.onDiskRemoved()
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if stat.ModTime().Equal(t.diskStat.ModTime()) && stat.Size() == t.diskStat.Size() {
+		return
+	}
+
+	t.onDiskChanged(stat)
+}
+
+func (t *Tab) onDiskChanged(stat os.FileInfo) {
+	//do not ask again about the same change
+	prev := t.diskStat
+	t.diskStat = stat
+
+	message := fmt.Sprintf("File `%s` has been changed on disk.", t.Filename)
+
+	if !t.Dirty {
+		t.ShowInfoBar("disk", gtk.MESSAGE_INFO, message, []string{"Reload", "Ignore"}, func(n int) {
+			if n == 0 {
+				t.Reload()
+			}
+		})
+		return
+	}
+
+	buttons := []string{"Reload", "Keep Mine"}
+	if t.Encoding != CHARSET_BINARY && t.large == nil && prev != nil {
+		buttons = append(buttons, "Merge")
+	}
+
+	message += " The buffer also has unsaved changes."
+	t.ShowInfoBar("disk", gtk.MESSAGE_WARNING, message, buttons, func(n int) {
+		switch n {
+		case 0:
+			t.Reload()
+		case 2:
+			t.MergeFromDisk()
+		}
+	})
+}
+
+func (t *Tab) onDiskRemoved() {
+	prev := t.diskStat
+	t.diskStat = nil
+
+	if moved := lookupMovedFile(prev, filepath.Dir(t.Filename)); moved != "" {
+		message := fmt.Sprintf("File `%s` has been moved to `%s`.", t.Filename, moved)
+		t.ShowInfoBar("disk", gtk.MESSAGE_WARNING, message, []string{"Follow", "Ignore"}, func(n int) {
+			if n == 0 {
+				t.SetFilename(moved)
+				t.UpdateDiskState(t.diskText)
+			}
+		})
+		return
+	}
+
+	message := fmt.Sprintf("File `%s` has been deleted or moved.", t.Filename)
+	t.ShowInfoBar("disk", gtk.MESSAGE_WARNING, message, []string{"Save", "Close", "Ignore"}, func(n int) {
+		switch n {
+		case 0:
+			ui.SaveTab(t)
+		case 1:
+			t.close()
+		}
+	})
+}
+
+// lookupMovedFile search for the same file in the directory, it works for renames inside one directory
+func lookupMovedFile(prev os.FileInfo, dir string) string {
+	if prev == nil {
+		return ""
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, e := range entries {
+		filename := filepath.Join(dir, e.Name())
+		stat, err := os.Stat(filename)
+		if err == nil && os.SameFile(prev, stat) {
+			return filename
+		}
+	}
+
+	return ""
+}
+
+// Reload replace text with the content of the file on disk
+func (t *Tab) Reload() {
+	t.Revert("")
+}
+
+// Revert replace text with the content of the file decoded from the encoding, empty encoding
+// is detected. Replacement is one undo step, cursor and scroll position are kept
+func (t *Tab) Revert(encoding string) {
+	if len(t.Filename) == 0 {
+		return
+	}
+
+	if t.large != nil {
+		if err := t.ReloadLarge(); err != nil {
+			errorMessage(err)
+			log.Println(err)
+			return
+		}
+		t.SetSavePoint()
+		t.UpdateDiskState("")
+		return
+	}
+
+	text, err := t.ReadFileEncoding(t.Filename, encoding)
+	if err != nil {
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	var iter gtk.TextIter
+	t.sourcebuffer.GetIterAtMark(&iter, t.sourcebuffer.GetInsert())
+	line, offset := iter.GetLine(), iter.GetLineOffset()
+	scroll := t.swin.GetVAdjustment().GetValue()
+
+	var start, end gtk.TextIter
+	t.sourcebuffer.GetStartIter(&start)
+	t.sourcebuffer.GetEndIter(&end)
+
+	beginUserAction(t.sourcebuffer)
+	t.sourcebuffer.Delete(&start, &end)
+	t.sourcebuffer.GetStartIter(&start)
+	t.sourcebuffer.Insert(&start, text)
+	endUserAction(t.sourcebuffer)
+	t.tagEscapes()
+
+	//cursor stays on the same line and column if they still exist
+	lines := strings.Split(text, "\n")
+	if line >= len(lines) {
+		line = len(lines) - 1
+	}
+	if n := utf8.RuneCountInString(lines[line]); offset > n {
+		offset = n
+	}
+	t.sourcebuffer.GetIterAtLineOffset(&iter, line, offset)
+	t.sourcebuffer.PlaceCursor(&iter)
+
+	glib.IdleAdd(func() bool {
+		t.swin.GetVAdjustment().SetValue(scroll)
+		return false
+	})
+
+	if issetLanguage(t.Language) {
+		t.sourcebuffer.SetLanguage(langManager.GetLanguage(t.Language))
+	}
+
+	t.SetSavePoint()
+	t.UpdateDiskState(text)
+	t.UpdateMenuSeleted()
+	t.WarnMixedLineEndings()
+	t.OfferEncodingCandidates()
+}
+
+// MergeFromDisk three-way merge of the buffer and the file on disk, conflicts are marked in text
+func (t *Tab) MergeFromDisk() {
+	base := t.diskText
+	mine := t.GetText(true)
+
+	theirs, theirsEscapes, err := t.readDiskText()
+	if err != nil {
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	merged, conflicts := merge3(base, mine, theirs)
+
+	//merged lines are taken from both sides, so escapes are found by lines which contain them
+	escapes := escapesByLine(mine, t.taggedEscapes())
+	for line, cols := range escapesByLine(theirs, theirsEscapes) {
+		escapes[line] = append(escapes[line], cols...)
+	}
+
+	var start, end gtk.TextIter
+	t.sourcebuffer.GetStartIter(&start)
+	t.sourcebuffer.GetEndIter(&end)
+
+	beginUserAction(t.sourcebuffer)
+	t.sourcebuffer.Delete(&start, &end)
+	t.sourcebuffer.GetStartIter(&start)
+	t.sourcebuffer.Insert(&start, merged)
+	endUserAction(t.sourcebuffer)
+
+	t.escapes = lineEscapes(merged, escapes)
+	t.tagEscapes()
+
+	t.UpdateDiskState(theirs)
+
+	if conflicts > 0 {
+		message := fmt.Sprintf("Merged with %d conflict(s), they are marked with `<<<<<<<` and `>>>>>>>`.", conflicts)
+		t.ShowInfoBar("merge", gtk.MESSAGE_WARNING, message, []string{"OK"}, nil)
+	}
+}
+
+// readDiskText returns text of the file decoded with the current encoding, compression and
+// byte order mark of the tab and offsets of escapes, unlike ReadFile the tab is not changed
+func (t *Tab) readDiskText() (string, []int, error) {
+	data, err := readRaw(t.Filename)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(t.Compression) > 0 {
+		data, err = decompress(data, t.Compression)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed read file `%s`, %s", t.Filename, err)
+		}
+	}
+
+	if t.BOM {
+		data = bytes.TrimPrefix(data, bomFor(t.Encoding))
+	}
+
+	segments, err := decodeEscaped(data, t.Encoding)
+	if err != nil {
+		return "", nil, err
+	}
+
+	text, escapes := joinSegments(segments)
+	return text, escapes, nil
+}
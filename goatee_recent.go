@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/mattn/go-gtk/gtk"
+	"github.com/naoina/toml"
+)
+
+// Recent list of recently opened files, pinned files are never pushed out of the list
+type Recent struct {
+	Files []RecentFile `toml:"files"`
+
+	menu *gtk.Menu
+}
+
+type RecentFile struct {
+	Filename string `toml:"filename"`
+	Pinned   bool   `toml:"pinned"`
+}
+
+func recentFilename() string {
+	return path.Join(stateDir(), "recent.toml")
+}
+
+// LoadRecent read list of recent files, files which no longer exist are pruned
+func LoadRecent() *Recent {
+	r := new(Recent)
+
+	data, err := ioutil.ReadFile(recentFilename())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return r
+	}
+
+	if err := toml.Unmarshal(data, r); err != nil {
+		log.Printf("failed decode recent files `%s`, %s", recentFilename(), err)
+	}
+
+	r.prune()
+	return r
+}
+
+func (r *Recent) Write() {
+	data, err := toml.Marshal(*r)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	os.MkdirAll(stateDir(), 0700)
+	if err := writeFileAtomic(recentFilename(), data); err != nil {
+		log.Println("failed write recent files,", err)
+	}
+}
+
+// prune remove files which no longer exist and unpinned files above the limit
+func (r *Recent) prune() {
+	var files []RecentFile
+	unpinned := 0
+	for _, f := range r.Files {
+		//remote locations are not checked, it may be slow
+		if _, err := os.Stat(f.Filename); err != nil && !isURI(f.Filename) {
+			continue
+		}
+
+		if !f.Pinned {
+			if unpinned >= conf.Recent.MaxItems {
+				continue
+			}
+			unpinned++
+		}
+		files = append(files, f)
+	}
+	r.Files = files
+}
+
+// absPath returns absolute path of local file, remote locations are returned as is
+func absPath(filename string) string {
+	if isURI(filename) {
+		return filename
+	}
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
+
+func (r *Recent) lookup(filename string) int {
+	for i, f := range r.Files {
+		if f.Filename == filename {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add move file to the top of the list
+func (r *Recent) Add(filename string) {
+	if len(filename) == 0 {
+		return
+	}
+
+	filename = absPath(filename)
+
+	f := RecentFile{Filename: filename}
+	if i := r.lookup(filename); i >= 0 {
+		f = r.Files[i]
+		r.Files = append(r.Files[:i], r.Files[i+1:]...)
+	}
+	r.Files = append([]RecentFile{f}, r.Files...)
+
+	r.Update()
+}
+
+// TogglePin pin or unpin file, not listed file is added pinned
+func (r *Recent) TogglePin(filename string) {
+	filename = absPath(filename)
+
+	i := r.lookup(filename)
+	if i < 0 {
+		r.Files = append([]RecentFile{{Filename: filename, Pinned: true}}, r.Files...)
+	} else {
+		r.Files[i].Pinned = !r.Files[i].Pinned
+	}
+
+	r.Update()
+}
+
+// Clear remove all not pinned files
+func (r *Recent) Clear() {
+	var files []RecentFile
+	for _, f := range r.Files {
+		if f.Pinned {
+			files = append(files, f)
+		}
+	}
+	r.Files = files
+
+	r.Update()
+}
+
+// Update prune and save the list, then rebuild the menu
+func (r *Recent) Update() {
+	r.prune()
+	r.Write()
+	r.UpdateMenu()
+}
+
+// UpdateMenu fill submenu File > Recent, pinned files are shown first
+func (r *Recent) UpdateMenu() {
+	//previous submenu is destroyed by replacing
+	r.menu = gtk.NewMenu()
+	w := ui.menu.uiManager.GetWidget("/MenuBar/File/Recent")
+	item := &gtk.MenuItem{Item: gtk.Item{Bin: gtk.Bin{Container: gtk.Container{Widget: *w}}}}
+	item.SetSubmenu(r.menu)
+
+	var pinned, recent []RecentFile
+	for _, f := range r.Files {
+		if f.Pinned {
+			pinned = append(pinned, f)
+		} else {
+			recent = append(recent, f)
+		}
+	}
+
+	for _, f := range pinned {
+		r.appendItem("★ "+path.Base(f.Filename), f.Filename)
+	}
+	if len(pinned) > 0 {
+		r.menu.Append(gtk.NewSeparatorMenuItem())
+	}
+
+	for _, f := range recent {
+		r.appendItem(path.Base(f.Filename), f.Filename)
+	}
+	if len(recent) > 0 {
+		r.menu.Append(gtk.NewSeparatorMenuItem())
+	}
+
+	pin := gtk.NewMenuItemWithLabel("Pin/Unpin Current File")
+	pin.Connect("activate", func() {
+		if t := ui.GetCurrentTab(); t != nil && len(t.Filename) > 0 {
+			r.TogglePin(t.Filename)
+		}
+	})
+	r.menu.Append(pin)
+
+	clear := gtk.NewMenuItemWithLabel("Clear Recent")
+	clear.Connect("activate", r.Clear)
+	r.menu.Append(clear)
+
+	r.menu.ShowAll()
+}
+
+func (r *Recent) appendItem(label, filename string) {
+	item := gtk.NewMenuItemWithLabel(label)
+	item.SetTooltipText(filename)
+	item.Connect("activate", func() {
+		if _, err := os.Stat(filename); err != nil && !isURI(filename) {
+			r.Update()
+			return
+		}
+		ui.NewTab(filename)
+	})
+	r.menu.Append(item)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// modeBits permissions of the original file kept by the new file
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// errDirNotWritable temporary file can not be created next to the target
+var errDirNotWritable = errors.New("directory is not writable")
+
+// saveFile write document of the user atomically, previous content is kept as backup if
+// it is enabled
+func saveFile(filename string, data []byte) error {
+	return saveFileStream(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// saveFileStream same as saveFile, but content is written by function
+func saveFileStream(filename string, write func(w io.Writer) error) error {
+	err := writeFileStream(filename, conf.Save.Backup, write)
+
+	//directory is not writable, but file may be
+	if err == errDirNotWritable {
+		return writeFileInPlace(filename, conf.Save.Backup, write)
+	}
+
+	return err
+}
+
+// writeFileAtomic write data to the temporary file placed next to filename and rename it over filename,
+// mode, owner and extended attributes of the original file are kept, symlinks are followed,
+// it is used for internal files, so backup is never created
+func writeFileAtomic(filename string, data []byte) error {
+	return writeFileStream(filename, false, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileStream same as writeFileAtomic, but content is written by function
+func writeFileStream(filename string, backup bool, write func(w io.Writer) error) error {
+	target, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		target = filename
+	}
+
+	var mode os.FileMode = 0644
+	stat, err := os.Stat(target)
+	if err == nil {
+		mode = stat.Mode() & modeBits
+	}
+
+	dir, base := filepath.Split(target)
+	tmp, err := ioutil.TempFile(dir, "."+base+".goatee-")
+	if err != nil {
+		if os.IsPermission(err) {
+			return errDirNotWritable
+		}
+		return err
+	}
+
+	if err := writeTempFile(tmp, write, mode, stat, target); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if stat != nil && backup {
+		if err := backupFile(target, true); err != nil {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed create backup, %s", err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// writeFileInPlace truncate and rewrite the file, it is not atomic, so it is used only if
+// temporary file can not be created next to the target, content is written to memory first
+// to not leave the file truncated if write function fails
+func writeFileInPlace(filename string, backup bool, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+
+	var mode os.FileMode = 0644
+	stat, err := os.Stat(filename)
+	if err == nil {
+		mode = stat.Mode().Perm()
+
+		//backup must not share inode with the file which is truncated
+		if backup {
+			if err := backupFile(filename, false); err != nil {
+				return fmt.Errorf("failed create backup, %s", err)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeTempFile(tmp *os.File, write func(w io.Writer) error, mode os.FileMode, stat os.FileInfo, target string) error {
+	defer tmp.Close()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+
+	//chown clears setuid and setgid bits, so mode is set after it
+	if stat != nil {
+		preserveAttrs(tmp, stat, target)
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	return tmp.Close()
+}
+
+// backupFile keep current content of file as `file~` or `file.20060102-150405~`, if link is
+// set the backup may be a hard link, it is valid only if the file is replaced by rename
+func backupFile(filename string, link bool) error {
+	backup := filename
+	if conf.Save.BackupTimestamp {
+		backup += "." + time.Now().Format("20060102-150405")
+	}
+	backup += conf.Save.BackupSuffix
+
+	if backup == filename {
+		return fmt.Errorf("backup suffix is empty")
+	}
+
+	os.Remove(backup)
+
+	//file will be replaced by rename, so old inode may be kept as backup
+	if link {
+		if err := os.Link(filename, backup); err == nil {
+			return nil
+		}
+	}
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
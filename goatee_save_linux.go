@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"syscall"
+)
+
+// accessWrite W_OK mode of access(2)
+const accessWrite = 0x2
+
+// canWriteFile check permissions by access(2) and read only flag of the mount by statfs(2),
+// unlike opening the file it does not block on FIFOs and does not touch devices
+func canWriteFile(filename string) bool {
+	if err := syscall.Access(filename, accessWrite); err != nil {
+		return false
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(filename, &st); err != nil {
+		return true
+	}
+
+	//ST_RDONLY of statfs flags has the same value as MS_RDONLY
+	return st.Flags&syscall.MS_RDONLY == 0
+}
+
+// preserveAttrs copy owner and extended attributes of the target file to the new file
+func preserveAttrs(f *os.File, stat os.FileInfo, target string) {
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		if int(st.Uid) != os.Getuid() || int(st.Gid) != os.Getgid() {
+			if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil {
+				log.Println("failed preserve owner,", err)
+			}
+		}
+	}
+
+	size, err := syscall.Listxattr(target, nil)
+	if err != nil || size == 0 {
+		return
+	}
+
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(target, list)
+	if err != nil {
+		return
+	}
+
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+
+		attr := string(name)
+		n, err := syscall.Getxattr(target, attr, nil)
+		if err != nil {
+			continue
+		}
+
+		value := make([]byte, n)
+		n, err = syscall.Getxattr(target, attr, value)
+		if err != nil {
+			continue
+		}
+
+		if err := syscall.Setxattr(f.Name(), attr, value[:n], 0); err != nil {
+			log.Printf("failed preserve xattr %s, %s", attr, err)
+		}
+	}
+}
+
+// lockFile take exclusive lock of the file, it is held while the file is open, returns false
+// if the lock is held by another process
+func lockFile(filename string) (*os.File, bool) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, false
+	}
+	return f, true
+}
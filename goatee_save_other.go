@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// canWriteFile check write permission bits of the file
+func canWriteFile(filename string) bool {
+	stat, err := os.Stat(filename)
+	return err == nil && stat.Mode().Perm()&0222 != 0
+}
+
+// preserveAttrs owner and extended attributes are supported only on linux
+func preserveAttrs(f *os.File, stat os.FileInfo, target string) {}
+
+// lockFile locking is supported only on linux, the file is opened to be closed by unlocking
+func lockFile(filename string) (*os.File, bool) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
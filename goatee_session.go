@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+	"github.com/naoina/toml"
+)
+
+const defaultSession = "default"
+
+// Session list of open tabs, it is saved at quit and restored at startup
+type Session struct {
+	Current int          `toml:"current"`
+	Tabs    []SessionTab `toml:"tabs"`
+}
+
+// SessionTab state of one tab, text of new tabs is stored in separate file
+type SessionTab struct {
+	Filename string `toml:"filename,omitempty"`
+	Untitled string `toml:"untitled,omitempty"`
+	Encoding string `toml:"encoding,omitempty"`
+	Language string `toml:"language,omitempty"`
+	Cursor   int    `toml:"cursor"`
+	TopLine  int    `toml:"top-line"`
+}
+
+// stateDir returns directory for goatee state files: XDG_STATE_HOME/goatee
+func stateDir() string {
+	statedir := os.Getenv("XDG_STATE_HOME")
+	if statedir == "" {
+		statedir = path.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return path.Join(statedir, "goatee")
+}
+
+func sessionFilename(name string) string {
+	if name == defaultSession {
+		return path.Join(stateDir(), "session.toml")
+	}
+	return path.Join(stateDir(), "sessions", name+".toml")
+}
+
+func untitledDir() string {
+	return path.Join(stateDir(), "untitled")
+}
+
+// sessionUntitledDir returns directory with texts of new tabs of the session, each session
+// has own directory, so saving one session does not touch texts of others
+func sessionUntitledDir(name string) string {
+	return path.Join(untitledDir(), name)
+}
+
+// validSessionName returns error if the name can not be used as a file name of the session
+func validSessionName(name string) error {
+	if len(name) == 0 || name == "." || name == ".." || strings.ContainsAny(name, "/\\"+string(os.PathSeparator)) {
+		return fmt.Errorf("invalid session name `%s`", name)
+	}
+	return nil
+}
+
+// removeSessionUntitled remove texts of new tabs left from the previous save of the session
+func removeSessionUntitled(name string) error {
+	if err := validSessionName(name); err != nil {
+		return err
+	}
+
+	dir := path.Clean(sessionUntitledDir(name))
+	if !strings.HasPrefix(dir, path.Clean(untitledDir())+"/") {
+		return fmt.Errorf("invalid session name `%s`", name)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	removeLegacyUntitled(name)
+	return nil
+}
+
+// lockSession returns true if the session belongs to this instance, the session is locked by
+// the first instance which uses it, other instances do not restore and do not overwrite it
+func (ui *UI) lockSession(name string) bool {
+	if _, ok := ui.sessionLocks[name]; ok {
+		return true
+	}
+	if validSessionName(name) != nil {
+		return false
+	}
+
+	filename := sessionFilename(name) + ".lock"
+	os.MkdirAll(filepath.Dir(filename), 0700)
+
+	f, ok := lockFile(filename)
+	if !ok {
+		return false
+	}
+	ui.sessionLocks[name] = f
+	return true
+}
+
+// unlockSession let other instances use the session
+func (ui *UI) unlockSession(name string) {
+	if f, ok := ui.sessionLocks[name]; ok {
+		f.Close()
+		delete(ui.sessionLocks, name)
+	}
+}
+
+// sessionNames returns names of saved named sessions
+func sessionNames() []string {
+	files, _ := filepath.Glob(path.Join(stateDir(), "sessions", "*.toml"))
+
+	var names []string
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(path.Base(f), ".toml"))
+	}
+	return names
+}
+
+// SaveSession write state of all tabs to the session file
+func (ui *UI) SaveSession(name string) error {
+	if !ui.lockSession(name) {
+		return fmt.Errorf("session `%s` is used by another instance", name)
+	}
+
+	if err := removeSessionUntitled(name); err != nil {
+		return err
+	}
+
+	//current is the index of notebook page, so tabs are written in order of pages
+	current := ui.notebook.GetCurrentPage()
+
+	s := Session{Current: current}
+	for n, t := range ui.orderedTabs() {
+		st := t.SessionState()
+
+		if len(t.Filename) == 0 {
+			//standard input can not be read again, so it is not restored
+			text := t.GetText(true)
+			if len(text) == 0 || t.isStdin() {
+				if n < current {
+					s.Current--
+				}
+				continue
+			}
+
+			os.MkdirAll(sessionUntitledDir(name), 0700)
+
+			st.Untitled = path.Join(name, fmt.Sprintf("%d.txt", n))
+			if err := ioutil.WriteFile(path.Join(untitledDir(), st.Untitled), []byte(text), 0600); err != nil {
+				return fmt.Errorf("failed save text of `%s`, %s", t.label.GetText(), err)
+			}
+		}
+
+		s.Tabs = append(s.Tabs, st)
+	}
+
+	data, err := toml.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	filename := sessionFilename(name)
+	os.MkdirAll(filepath.Dir(filename), 0700)
+
+	if err := writeFileAtomic(filename, data); err != nil {
+		return fmt.Errorf("failed save session `%s`, %s", name, err)
+	}
+
+	return nil
+}
+
+// removeLegacyUntitled remove texts of new tabs stored as `untitled/<name>-<n>.txt` by previous
+// versions, the name is matched exactly to not remove texts of sessions with the same prefix
+func removeLegacyUntitled(name string) {
+	files, _ := ioutil.ReadDir(untitledDir())
+	legacy := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `-[0-9]+\.txt$`)
+	for _, f := range files {
+		if !f.IsDir() && legacy.MatchString(f.Name()) {
+			os.Remove(path.Join(untitledDir(), f.Name()))
+		}
+	}
+}
+
+// LoadSession open tabs from the session file
+func (ui *UI) LoadSession(name string) error {
+	data, err := ioutil.ReadFile(sessionFilename(name))
+	if err != nil {
+		return err
+	}
+
+	var s Session
+	if err := toml.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed decode session `%s`, %s", name, err)
+	}
+
+	for _, st := range s.Tabs {
+		var t *Tab
+		if len(st.Untitled) > 0 {
+			text, err := ioutil.ReadFile(path.Join(untitledDir(), st.Untitled))
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			t = ui.NewTab("")
+			if t == nil {
+				continue
+			}
+			t.sourcebuffer.SetText(string(text))
+		} else {
+			if _, err := os.Stat(st.Filename); err != nil {
+				log.Println("skip session file,", err)
+				continue
+			}
+
+			t = ui.NewTab(st.Filename)
+			if t == nil {
+				continue
+			}
+			if len(st.Encoding) > 0 {
+				t.ChangeCurrEncoding(st.Encoding)
+			}
+		}
+
+		t.RestoreSessionState(st)
+	}
+
+	if s.Current >= 0 && s.Current < len(ui.tabs) {
+		ui.notebook.SetCurrentPage(s.Current)
+	}
+
+	return nil
+}
+
+// SessionState returns position of the cursor and of the scroll
+func (t *Tab) SessionState() SessionTab {
+	st := SessionTab{
+		Filename: t.Filename,
+		Encoding: t.Encoding,
+		Language: t.Language,
+	}
+
+	var iter gtk.TextIter
+	t.sourcebuffer.GetIterAtMark(&iter, t.sourcebuffer.GetInsert())
+	st.Cursor = iter.GetOffset()
+
+	t.sourceview.GetIterAtLocation(&iter, 0, int(t.swin.GetVAdjustment().GetValue()))
+	st.TopLine = iter.GetLine()
+
+	return st
+}
+
+func (t *Tab) RestoreSessionState(st SessionTab) {
+	if len(st.Language) > 0 && issetLanguage(st.Language) {
+		t.ChangeLanguage(st.Language)
+	}
+	t.UpdateMenuSeleted()
+
+	var iter gtk.TextIter
+	t.sourcebuffer.GetIterAtOffset(&iter, st.Cursor)
+	t.sourcebuffer.PlaceCursor(&iter)
+
+	//scroll after the text view is allocated
+	t.sourcebuffer.GetIterAtLine(&iter, st.TopLine)
+	mark := t.sourcebuffer.CreateMark("", &iter, true)
+	glib.IdleAdd(func() bool {
+		t.sourceview.ScrollToMark(mark, 0, true, 0, 0)
+		return false
+	})
+}
+
+func (ui *UI) SaveSessionAs() {
+	name := dialogSessionName("Save Session", ui.session)
+	if len(name) == 0 {
+		return
+	}
+
+	if err := ui.SaveSession(name); err != nil {
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	if ui.session != defaultSession && ui.session != name {
+		ui.unlockSession(ui.session)
+	}
+	ui.session = name
+}
+
+func (ui *UI) OpenSession() {
+	name := dialogSessionName("Load Session", "")
+	if len(name) == 0 {
+		return
+	}
+
+	if !ui.lockSession(name) {
+		err := fmt.Errorf("session `%s` is used by another instance", name)
+		errorMessage(err)
+		log.Println(err)
+		return
+	}
+
+	//new tabs are kept in the current session, so ask only about files
+	if err := ui.SaveSession(ui.session); err != nil {
+		log.Println(err)
+	}
+	if !ui.ConfirmClose(ui.fileTabs()) {
+		if name != ui.session && name != defaultSession {
+			ui.unlockSession(name)
+		}
+		return
+	}
+
+	for len(ui.tabs) > 0 {
+		ui.removeTab(len(ui.tabs) - 1)
+	}
+
+	if ui.session != defaultSession && ui.session != name {
+		ui.unlockSession(ui.session)
+	}
+	ui.session = name
+
+	if err := ui.LoadSession(name); err != nil {
+		errorMessage(err)
+		log.Println(err)
+	}
+
+	if len(ui.tabs) == 0 {
+		ui.NewTab("")
+	}
+}
+
+// dialogSessionName ask name of the session, saved sessions are offered in the combo box
+func dialogSessionName(title, name string) string {
+	dialog := gtk.NewDialog()
+	dialog.SetTitle(title)
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+
+	combo := gtk.NewComboBoxTextWithEntry()
+	for _, s := range sessionNames() {
+		combo.AppendText(s)
+	}
+
+	entry := combo.GetEntry()
+	if name != defaultSession {
+		entry.SetText(name)
+	}
+	entry.SetActivatesDefault(true)
+
+	dialog.GetVBox().PackStart(combo, false, false, 5)
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton(gtk.STOCK_OK, gtk.RESPONSE_OK)
+	dialog.SetDefaultResponse(gtk.RESPONSE_OK)
+	dialog.ShowAll()
+
+	var res string
+	if dialog.Run() == gtk.RESPONSE_OK {
+		res = strings.TrimSpace(entry.GetText())
+	}
+	dialog.Destroy()
+
+	if len(res) == 0 {
+		return ""
+	}
+	if err := validSessionName(res); err != nil {
+		errorMessage(err)
+		return ""
+	}
+
+	return res
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+)
+
+const stdinChunk = 32 << 10
+
+// stdinInterval how often read chunks are appended to the buffer, in milliseconds
+const stdinInterval = 100
+
+var errStdinClosed = errors.New("tab of standard input is closed")
+
+// stdinStart encoding detected by the first chunk and the chunk without BOM
+type stdinStart struct {
+	encoding string
+	head     []byte
+}
+
+// OpenStdin read standard input into a new tab, text is appended while the pipe is open.
+// Input is decoded by one converter, so characters cut by chunks and shift sequences of
+// stateful charsets are kept. Reading stops when the tab is closed
+func (ui *UI) OpenStdin() {
+	t := ui.NewTab("")
+	if t == nil {
+		return
+	}
+	t.label.SetTooltipText("standard input")
+
+	t.stdin = make(chan struct{})
+	done := t.stdin
+
+	first := make(chan []byte, 1)
+	start := make(chan stdinStart, 1)
+	chunks := make(chan []byte, 64)
+
+	go func() {
+		defer close(chunks)
+
+		buf := make([]byte, stdinChunk)
+		n, err := os.Stdin.Read(buf)
+		if err != nil && err != io.EOF {
+			log.Println("failed read stdin,", err)
+		}
+		if n == 0 {
+			close(first)
+			return
+		}
+		first <- buf[:n]
+
+		var s stdinStart
+		select {
+		case s = <-start:
+		case <-done:
+			return
+		}
+
+		if err := readStdin(s, &stdinWriter{chunks: chunks, done: done}); err != nil && err != errStdinClosed {
+			log.Println("failed read stdin,", err)
+		}
+	}()
+
+	//the first chunk is detected in the main loop, then the rest is converted by the reader
+	detected := false
+	var pending []byte
+	glib.TimeoutAdd(stdinInterval, func() bool {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+
+		if !detected {
+			select {
+			case data, ok := <-first:
+				if !ok {
+					return false
+				}
+				head := t.detectStdin(data)
+				start <- stdinStart{encoding: t.Encoding, head: head}
+				detected = true
+			default:
+				return true
+			}
+		}
+
+		for {
+			select {
+			case data, ok := <-chunks:
+				if !ok {
+					if len(pending) > 0 {
+						t.appendStdin(pending)
+					}
+					return false
+				}
+
+				//CR may be followed by LF in the next chunk
+				data = append(pending, data...)
+				pending = nil
+				if n := len(data); n > 0 && data[n-1] == '\r' {
+					data, pending = data[:n-1], []byte{'\r'}
+				}
+				t.appendStdin(data)
+			default:
+				return true
+			}
+		}
+	})
+}
+
+// readStdin convert the first chunk and the rest of standard input to utf-8, bytes not valid
+// in the charset are replaced by the replacement character
+func readStdin(s stdinStart, w io.Writer) error {
+	from := s.encoding
+	if from == CHARSET_ASCII || from == CHARSET_BINARY || len(from) == 0 {
+		from = CHARSET_UTF8
+	}
+
+	converter, err := NewConverter(from, CHARSET_UTF8)
+	if err != nil {
+		return err
+	}
+	defer converter.Close()
+
+	converter.Invalid = func(b byte) {
+		w.Write([]byte("�"))
+	}
+
+	return converter.Convert(w, io.MultiReader(bytes.NewReader(s.head), os.Stdin))
+}
+
+// stdinWriter pass converted text to the main loop, writing fails after the tab is closed
+type stdinWriter struct {
+	chunks chan<- []byte
+	done   <-chan struct{}
+}
+
+func (w *stdinWriter) Write(p []byte) (int, error) {
+	select {
+	case w.chunks <- append([]byte{}, p...):
+		return len(p), nil
+	case <-w.done:
+		return 0, errStdinClosed
+	}
+}
+
+// isStdin returns true for the tab of standard input which is not saved to a file yet,
+// such tab is not stored in sessions and swap files
+func (t *Tab) isStdin() bool {
+	return t.stdin != nil && len(t.Filename) == 0
+}
+
+// detectStdin detect encoding and language by the first chunk of input, returns chunk without BOM
+func (t *Tab) detectStdin(data []byte) []byte {
+	data, bomCharset := stripBOM(data)
+	if len(bomCharset) > 0 {
+		t.Encoding = bomCharset
+	} else if encoding, err := t.DetectEncoding(data); err == nil && encoding != CHARSET_BINARY {
+		t.Encoding = encoding
+	}
+
+	t.LineEnding, t.mixedLineEndings = DetectLineEnding(data)
+
+	t.Language = t.DetectLanguage(data)
+	if issetLanguage(t.Language) {
+		t.sourcebuffer.SetLanguage(langManager.GetLanguage(t.Language))
+	}
+
+	t.UpdateMenuSeleted()
+	return data
+}
+
+// appendStdin insert converted chunk to the end of buffer, view follows the end if the cursor
+// was there
+func (t *Tab) appendStdin(data []byte) {
+	data = NormalizeLineEndings(data)
+
+	var cursor, end gtk.TextIter
+	t.sourcebuffer.GetIterAtMark(&cursor, t.sourcebuffer.GetInsert())
+	t.sourcebuffer.GetEndIter(&end)
+	follow := cursor.GetOffset() == end.GetOffset()
+
+	t.sourcebuffer.BeginNotUndoableAction()
+	t.sourcebuffer.Insert(&end, string(data))
+	t.sourcebuffer.EndNotUndoableAction()
+
+	if follow {
+		t.sourcebuffer.GetEndIter(&end)
+		t.sourcebuffer.PlaceCursor(&end)
+		t.Scroll(end)
+	}
+}
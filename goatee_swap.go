@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mattn/go-gtk/glib"
+	"github.com/mattn/go-gtk/gtk"
+	gsv "github.com/mattn/go-gtk/gtksourceview"
+)
+
+const swapHeader = "goatee-swap"
+
+// Swap snapshot of the buffer, also it is the lock of the file for other instances
+type Swap struct {
+	Filename string
+	Encoding string
+	Pid      int
+	Dirty    bool
+	Text     string
+
+	swapfile string
+}
+
+func swapDir() string {
+	return path.Join(stateDir(), "swap")
+}
+
+// swapFilename returns name of the swap file, path separators of filename are replaced by `%`
+func (t *Tab) swapFilename() string {
+	if len(t.Filename) == 0 {
+		return path.Join(swapDir(), fmt.Sprintf("%%new-%d-%s.swp", os.Getpid(), t.label.GetText()))
+	}
+
+	filename, err := filepath.Abs(t.Filename)
+	if err != nil || isURI(t.Filename) {
+		filename = t.Filename
+	}
+	return path.Join(swapDir(), strings.Replace(filename, "/", "%", -1)+".swp")
+}
+
+func readSwap(swapfile string) (*Swap, error) {
+	data, err := ioutil.ReadFile(swapfile)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := bytes.SplitN(data, []byte("\n"), 3)
+	if len(lines) != 3 {
+		return nil, fmt.Errorf("invalid swap file `%s`", swapfile)
+	}
+
+	header := strings.Fields(string(lines[0]))
+	if len(header) < 3 || header[0] != swapHeader {
+		return nil, fmt.Errorf("invalid swap file `%s`", swapfile)
+	}
+
+	s := &Swap{
+		Filename: string(lines[1]),
+		Text:     string(lines[2]),
+		Dirty:    header[2] == "1",
+		swapfile: swapfile,
+	}
+
+	s.Pid, err = strconv.Atoi(header[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid swap file `%s`, %s", swapfile, err)
+	}
+
+	if len(header) > 3 {
+		s.Encoding = header[3]
+	}
+
+	return s, nil
+}
+
+// Alive returns true if the instance which has written the swap is still running
+func (s *Swap) Alive() bool {
+	if s.Pid == os.Getpid() {
+		return true
+	}
+
+	p, err := os.FindProcess(s.Pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// WriteSwap write snapshot of the buffer, text is stored only for modified buffer
+func (t *Tab) WriteSwap() {
+	if !conf.Swap.Enabled || t.swapLocked || t.large != nil || t.Encoding == CHARSET_BINARY {
+		return
+	}
+
+	if len(t.Filename) == 0 && !t.Dirty || t.isStdin() {
+		return
+	}
+
+	dirty := 0
+	var text string
+	if t.Dirty {
+		dirty = 1
+		text = t.GetText(true)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d %d %s\n%s\n", swapHeader, os.Getpid(), dirty, t.Encoding, t.Filename)
+	buf.WriteString(text)
+
+	os.MkdirAll(swapDir(), 0700)
+
+	t.swapfile = t.swapFilename()
+	if err := writeSwapFile(t.swapfile, buf.Bytes()); err != nil {
+		log.Println("failed write swap file,", err)
+		return
+	}
+	t.swapChanged = false
+}
+
+// writeSwapFile replace the swap file by temporary one, unlike writeFileAtomic it does not
+// keep attributes and does not sync, swap is rewritten every few seconds and is readable
+// only by the user
+func writeSwapFile(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (t *Tab) RemoveSwap() {
+	if len(t.swapfile) == 0 {
+		return
+	}
+
+	if s, err := readSwap(t.swapfile); err == nil && s.Pid == os.Getpid() {
+		os.Remove(t.swapfile)
+	}
+	t.swapfile = ""
+}
+
+// CheckSwap warn if the file is opened by other instance, or offer recovery if it crashed
+func (t *Tab) CheckSwap() {
+	if !conf.Swap.Enabled {
+		return
+	}
+
+	s, err := readSwap(t.swapFilename())
+	if err != nil {
+		t.WriteSwap()
+		return
+	}
+
+	if s.Alive() {
+		if s.Pid != os.Getpid() {
+			t.swapLocked = true
+			message := fmt.Sprintf("File `%s` is already opened in other goatee (pid %d).", t.Filename, s.Pid)
+			t.ShowInfoBar("swap", gtk.MESSAGE_WARNING, message, []string{"OK"}, nil)
+		}
+		return
+	}
+
+	if !s.Dirty {
+		t.WriteSwap()
+		return
+	}
+
+	t.OfferRecovery(s)
+}
+
+// OfferRecovery show text left by crashed instance
+func (t *Tab) OfferRecovery(s *Swap) {
+	message := fmt.Sprintf("Found unsaved changes of `%s` left by crashed goatee.", t.label.GetText())
+	t.ShowInfoBar("swap", gtk.MESSAGE_WARNING, message, []string{"Recover", "Show Diff", "Discard"}, func(n int) {
+		switch n {
+		case 0:
+			t.sourcebuffer.SetText(s.Text)
+
+			//text is stored in utf-8, encoding is the one the buffer had before crash
+			if len(s.Encoding) > 0 && s.Encoding != CHARSET_BINARY && t.Encoding != CHARSET_BINARY {
+				t.Encoding = s.Encoding
+				t.UpdateMenuSeleted()
+			}
+			os.Remove(s.swapfile)
+			t.WriteSwap()
+		case 1:
+			dialogDiff(t.label.GetText(), unifiedDiff(t.GetText(true), s.Text, "disk", "recovered"))
+			t.OfferRecovery(s)
+		case 2:
+			os.Remove(s.swapfile)
+			t.WriteSwap()
+		}
+	})
+}
+
+// RecoverSwaps open files and new tabs which have swap files left by crashed instances
+func (ui *UI) RecoverSwaps() {
+	if !conf.Swap.Enabled {
+		return
+	}
+
+	files, _ := filepath.Glob(path.Join(swapDir(), "*.swp"))
+	for _, swapfile := range files {
+		s, err := readSwap(swapfile)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if s.Alive() {
+			continue
+		}
+
+		if !s.Dirty {
+			os.Remove(swapfile)
+			continue
+		}
+
+		if len(s.Filename) > 0 {
+			if _, _, ok := ui.LookupTab(s.Filename); !ok {
+				ui.NewTab(s.Filename)
+			}
+			continue
+		}
+
+		t := ui.NewTab("")
+		if t != nil {
+			t.OfferRecovery(s)
+		}
+	}
+}
+
+// AutosaveSwaps periodically write snapshots of modified buffers
+func (ui *UI) AutosaveSwaps() {
+	last := time.Now()
+	glib.TimeoutAdd(1000, func() bool {
+		if time.Since(last) < time.Duration(conf.Swap.Interval)*time.Second {
+			return true
+		}
+		last = time.Now()
+
+		for _, t := range ui.tabs {
+			if t.swapChanged {
+				t.WriteSwap()
+			}
+		}
+		return true
+	})
+}
+
+func dialogDiff(title, diff string) {
+	dialog := gtk.NewDialog()
+	dialog.SetTitle(title)
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+	dialog.SetDefaultSize(600, 400)
+
+	buffer := gsv.NewSourceBuffer()
+	if issetLanguage("diff") {
+		buffer.SetLanguage(langManager.GetLanguage("diff"))
+	}
+	buffer.SetStyleScheme(conf.schemeManager.GetScheme(conf.TextView.StyleScheme))
+	buffer.SetText(diff)
+
+	view := gsv.NewSourceViewWithBuffer(buffer)
+	view.SetEditable(false)
+	view.ModifyFontEasy(conf.TextView.Font)
+
+	swin := gtk.NewScrolledWindow(nil, nil)
+	swin.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	swin.SetShadowType(gtk.SHADOW_IN)
+	swin.Add(view)
+
+	dialog.GetVBox().PackStart(swin, true, true, 0)
+	dialog.AddButton(gtk.STOCK_CLOSE, gtk.RESPONSE_CLOSE)
+	dialog.ShowAll()
+	dialog.Run()
+	dialog.Destroy()
+}
@@ -23,23 +23,53 @@ import (
 
 	gsv "github.com/mattn/go-gtk/gtksourceview"
 
-	iconv "github.com/djimenez/iconv-go"
 	"github.com/saintfish/chardet"
 )
 
 type Tab struct {
-	Filename string
-	File     *os.File
-	Encoding string
-	Language string
-	ReadOnly bool
-	Dirty    bool
+	Filename   string
+	File       *os.File
+	Encoding   string
+	BOM        bool
+	LineEnding string
+	Language   string
+	ReadOnly   bool
+	Dirty      bool
+
+	Compression    string
+	compressLevel  int
+	compressWarned bool
+
+	diskStat os.FileInfo
+	diskText string
+	monitor  *FileMonitor
+
+	large *LargeFile
+
+	editorconfig EditorConfig
+
+	mixedLineEndings bool
+
+	encodingCandidates []chardet.Result
+
+	escapes   []int
+	tagEscape *gtk.TextTag
+
+	swapfile    string
+	swapChanged bool
+	swapLocked  bool
+
+	//stdin is closed when the tab is closed to stop reading of standard input
+	stdin chan struct{}
 
 	eventbox *gtk.EventBox
 	tab      *gtk.HBox
 	label    *gtk.Label
+	lock     *gtk.Image
 	closeBtn *gtk.Button
 
+	page         *gtk.VBox
+	infobars     map[string]*gtk.InfoBar
 	swin         *gtk.ScrolledWindow
 	sourceview   *gsv.SourceView
 	sourcebuffer *gsv.SourceBuffer
@@ -54,6 +84,8 @@ type Tab struct {
 	findwrap         bool
 	tagfind          *gtk.TextTag
 	tagfindCurrent   *gtk.TextTag
+
+	tagUnencodable *gtk.TextTag
 }
 
 func NewTab(filename string) (t *Tab) {
@@ -65,27 +97,18 @@ func NewTab(filename string) (t *Tab) {
 		var ok bool
 		var n int
 
-		//reload if this file already open
+		//reload if this file already open, it is undoable
 		if t, n, ok = ui.LookupTab(filename); ok {
 			ui.notebook.SetCurrentPage(n)
-
-			text, err := t.ReadFile(filename)
-			if err != nil {
-				errorMessage(err)
-				log.Println(err)
-				return
-			}
-			t.sourcebuffer.SetText(text)
-			t.Dirty = false
-			t.SetTabFGColor(conf.Tabs.FGNormal)
-			//TODO: reopen
+			t.Revert("")
 			return nil
 		}
 	}
 
 	t = &Tab{
-		Encoding: CHARSET_UTF8,
-		Language: "sh",
+		Encoding:   CHARSET_UTF8,
+		LineEnding: LINE_ENDING_LF,
+		Language:   "sh",
 	}
 
 	if len(filename) == 0 {
@@ -95,10 +118,16 @@ func NewTab(filename string) (t *Tab) {
 		t.Filename = filename
 	}
 
+	//empty new tab is replaced by the file, standard input may still be read to its tab
 	if len(t.Filename) > 0 {
 		ct := ui.GetCurrentTab()
-		if ct != nil && len(ct.Filename) == 0 && !ct.Dirty {
-			ct.Close()
+		if ct != nil && len(ct.Filename) == 0 && !ct.Dirty && ct.stdin == nil {
+			for n := range ui.tabs {
+				if ui.tabs[n] == ct {
+					ui.removeTab(n)
+					break
+				}
+			}
 		}
 	}
 
@@ -113,16 +142,57 @@ func NewTab(filename string) (t *Tab) {
 
 	t.swin.Add(t.sourceview)
 
+	t.page = gtk.NewVBox(false, 0)
+	t.page.PackEnd(t.swin, true, true, 0)
+
 	t.label = gtk.NewLabel(path.Base(filename))
 	t.label.SetTooltipText(filename)
 
+	t.lock = gtk.NewImageFromStock(gtk.STOCK_DIALOG_AUTHENTICATION, gtk.ICON_SIZE_MENU)
+	t.lock.SetNoShowAll(true)
+
 	t.tab = gtk.NewHBox(false, 0)
+	t.tab.PackStart(t.lock, false, false, 0)
 	t.tab.PackStart(t.label, true, true, 0)
 
-	if len(t.Filename) > 0 {
+	if isURI(t.Filename) {
+
+		text, err := t.ReadFile(filename)
+		if err != nil {
+			errorMessage(err)
+			log.Println(err)
+			return
+		}
+
+		t.sourcebuffer.BeginNotUndoableAction()
+		t.sourcebuffer.SetText(text)
+		t.sourcebuffer.EndNotUndoableAction()
+		t.tagEscapes()
+
+		t.CheckSwap()
+		t.WarnMixedLineEndings()
+		t.OfferEncodingCandidates()
+		t.WarnRemote()
+
+		if !isWritable(filename) {
+			t.SetReadOnly(true)
+		}
+
+	} else if len(t.Filename) > 0 {
+
+		t.editorconfig = LoadEditorConfig(filename)
 
 		stat, err := os.Stat(filename)
-		if err == nil && !stat.IsDir() {
+		if err == nil && !stat.IsDir() && t.isLarge(stat) {
+
+			if err := t.OpenLarge(filename, stat); err != nil {
+				errorMessage(err)
+				log.Println(err)
+				return
+			}
+			t.UpdateDiskState("")
+
+		} else if err == nil && !stat.IsDir() {
 
 			text, err := t.ReadFile(filename)
 			if err != nil {
@@ -134,6 +204,16 @@ func NewTab(filename string) (t *Tab) {
 			t.sourcebuffer.BeginNotUndoableAction()
 			t.sourcebuffer.SetText(text)
 			t.sourcebuffer.EndNotUndoableAction()
+			t.tagEscapes()
+
+			t.UpdateDiskState(text)
+			t.CheckSwap()
+			t.WarnMixedLineEndings()
+			t.OfferEncodingCandidates()
+		}
+
+		if err == nil && !stat.IsDir() && !isWritable(filename) {
+			t.SetReadOnly(true)
 		}
 	}
 
@@ -151,8 +231,11 @@ func NewTab(filename string) (t *Tab) {
 	t.eventbox.ShowAll()
 
 	t.sourcebuffer.Connect("changed", t.onchange)
+	t.sourcebuffer.Connect("modified-changed", t.onModifiedChanged)
 	t.sourcebuffer.Connect("notify::cursor-moved", t.onMoveCursor) // notify::cursor-position for the old gtksourcebuffer
 
+	t.SetSavePoint()
+
 	return t
 }
 
@@ -163,11 +246,7 @@ func (t *Tab) ApplyConf() {
 	t.sourceview.ModifyFontEasy(conf.TextView.Font)
 	t.sourceview.SetShowLineNumbers(conf.TextView.LineNumbers)
 
-	if len(t.Filename) == 0 {
-		t.SetTabFGColor(conf.Tabs.FGNew)
-	} else {
-		t.SetTabFGColor(conf.Tabs.FGNormal)
-	}
+	t.updateTabColor()
 
 	t.tab.SetSizeRequest(-1, conf.Tabs.Height)
 
@@ -189,8 +268,8 @@ func (t *Tab) ApplyConf() {
 	}
 
 	if t.Encoding != CHARSET_BINARY {
-		t.sourceview.SetTabWidth(uint(conf.TextView.IndentWidth))
-		t.sourceview.SetInsertSpacesInsteadOfTabs(conf.TextView.IndentSpace)
+		//settings of .editorconfig take precedence over the global config
+		t.applyEditorConfig()
 
 		if conf.TextView.WordWrap {
 			t.sourceview.SetWrapMode(gtk.WRAP_WORD_CHAR)
@@ -203,11 +282,26 @@ func (t *Tab) UpdateMenuSeleted() {
 	ui.NoActivate = true
 	if ra, ok := ui.encodings[t.Encoding]; ok {
 		ra.SetActive(true)
+	} else if len(t.Encoding) > 0 {
+		ui.addEncodingAction(t.Encoding).SetActive(true)
 	}
 
 	if ra, ok := ui.languages[t.Language]; ok {
 		ra.SetActive(true)
 	}
+
+	if ra, ok := ui.lineEndings[t.LineEnding]; ok {
+		ra.SetActive(true)
+	}
+	//pages of the large file are saved as is
+	for _, ra := range ui.lineEndings {
+		ra.SetSensitive(t.large == nil)
+	}
+
+	ui.bom.SetActive(t.BOM)
+	//charsets without own mark, ex: generic UTF-16 writes BOM by itself
+	ui.bom.SetSensitive(bomFor(t.Encoding) != nil && t.large == nil)
+	ui.readOnly.SetActive(t.ReadOnly)
 	ui.NoActivate = false
 }
 
@@ -215,16 +309,48 @@ func (t *Tab) onTabPress(ctx *glib.CallbackContext) {
 	arg := ctx.Args(0)
 	event := *(**gdk.EventButton)(unsafe.Pointer(&arg))
 
-	if event.Button == 2 {
-		if _, n, ok := ui.LookupTab(t.Filename); ok {
-			ui.CloseTab(n)
-			return
+	switch event.Button {
+	case 2:
+		t.close()
+	case 3:
+		t.popupTabMenu(event)
+	}
+}
+
+// popupTabMenu show context menu of the tab label
+func (t *Tab) popupTabMenu(event *gdk.EventButton) {
+	menu := gtk.NewMenu()
+
+	items := []struct {
+		label string
+		f     func()
+	}{
+		{"Close", t.close},
+		{"Close Other Tabs", func() { ui.CloseOthers(t) }},
+		{"Close Tabs to the Right", func() { ui.CloseRight(t) }},
+		{"Close All Tabs", ui.CloseAll},
+		{"", nil},
+		{"Save", func() { ui.SaveTab(t) }},
+		{"Save All", ui.SaveAll},
+	}
+
+	for _, i := range items {
+		if i.f == nil {
+			menu.Append(gtk.NewSeparatorMenuItem())
+			continue
 		}
+
+		item := gtk.NewMenuItemWithLabel(i.label)
+		item.Connect("activate", i.f)
+		menu.Append(item)
 	}
+
+	menu.ShowAll()
+	menu.Popup(nil, nil, nil, nil, uint(event.Button), event.Time)
 }
 
 func (t *Tab) close() {
-	if _, n, ok := ui.LookupTab(t.Filename); ok {
+	if n := ui.notebook.PageNum(t.page); n >= 0 {
 		ui.CloseTab(n)
 	}
 }
@@ -234,61 +360,253 @@ func (t *Tab) Close() {
 		t.File.Close()
 	}
 
+	t.RemoveSwap()
+	t.monitor.Cancel()
+
+	if t.stdin != nil {
+		close(t.stdin)
+		t.stdin = nil
+	}
+
 	t = nil
 }
 
-func (t *Tab) ReadFile(filename string) (string, error) {
-	var err error
-	t.File, err = os.Open(filename)
+// readRaw returns content of the file as it is stored, URIs are read through GIO
+func readRaw(filename string) ([]byte, error) {
+	if isURI(filename) {
+		return gioReadURI(filename)
+	}
+
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		err := fmt.Errorf("failed open file  `%s`, %s", filename, err)
-		return "", err
+		return nil, fmt.Errorf("failed read file `%s`, %s", filename, err)
+	}
+	return data, nil
+}
+
+// readFileData returns decompressed content of the file and its compression, unlike readData
+// the tab is not changed
+func readFileData(filename string) ([]byte, string, error) {
+	data, err := readRaw(filename)
+	if err != nil {
+		return nil, "", err
 	}
-	defer t.File.Close()
 
-	data, err := ioutil.ReadAll(t.File)
+	data, compression, _, _ := decompressData(data)
+	return data, compression, nil
+}
+
+// readData returns content of the file, URIs are read through GIO, compressed files are
+// decompressed and format is remembered to compress them back on save, file which fails
+// to decompress is read as is
+func (t *Tab) readData(filename string) ([]byte, error) {
+	var data []byte
+	var err error
+
+	if isURI(filename) {
+		data, err = gioReadURI(filename)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		t.File, err = os.Open(filename)
+		if err != nil {
+			err := fmt.Errorf("failed open file  `%s`, %s", filename, err)
+			return nil, err
+		}
+		defer t.File.Close()
+
+		data, err = ioutil.ReadAll(t.File)
+		if err != nil {
+			err := fmt.Errorf("failed read file  `%s`, %s", filename, err)
+			return nil, err
+		}
+	}
+
+	var warning error
+	data, t.Compression, t.compressLevel, warning = decompressData(data)
+	if warning != nil {
+		message := fmt.Sprintf("File `%s` looks compressed, but %s. It is opened as is and will be saved uncompressed.", filename, warning)
+		t.ShowInfoBar("compression", gtk.MESSAGE_WARNING, message, []string{"OK"}, nil)
+	} else {
+		t.HideInfoBar("compression")
+	}
+	return data, nil
+}
+
+func (t *Tab) ReadFile(filename string) (string, error) {
+	return t.ReadFileEncoding(filename, "")
+}
+
+// ReadFileEncoding read file and decode it from the encoding, if encoding is empty it is detected
+func (t *Tab) ReadFileEncoding(filename, encoding string) (string, error) {
+	data, err := t.readData(filename)
 	if err != nil {
-		err := fmt.Errorf("failed read file  `%s`, %s", filename, err)
 		return "", err
 	}
 
+	t.encodingCandidates = nil
+	t.escapes = nil
+
+	//encoding chosen by user before is used instead of detection
+	if len(encoding) == 0 {
+		encoding = ui.metadata.Encoding(filename)
+	}
+
 	if len(data) > 0 {
-		t.Encoding, err = t.DetectEncoding(data)
-		if err != nil {
-			t.Encoding = CHARSET_BINARY
+		raw := data
+
+		var bomCharset string
+		data, bomCharset = stripBOM(data)
+		t.BOM = len(bomCharset) > 0
+
+		if len(encoding) > 0 {
+			//byte order mark of other charset is a part of the text
+			var bom bool
+			encoding, bom = bomEncoding(encoding, bomCharset)
+			if t.BOM && !bom {
+				data = raw
+			}
+			t.BOM = bom
+			t.Encoding = encoding
+		} else if t.BOM {
+			t.Encoding = bomCharset
+		} else if charset, bom := t.editorconfig.Charset(); len(charset) > 0 {
+			t.Encoding, t.BOM = charset, bom
+		} else {
+			t.Encoding, err = t.DetectEncoding(data)
+			if err != nil {
+				t.Encoding = CHARSET_BINARY
+			}
 		}
 
-		if t.Encoding != CHARSET_UTF8 && t.Encoding != CHARSET_BINARY {
-			newdata, err := t.ChangeEncoding(data, CHARSET_UTF8, t.Encoding)
+		var segments []segment
+		if t.Encoding != CHARSET_BINARY {
+			segments, err = decodeEscaped(data, t.Encoding)
 			if err != nil {
 				errorMessage(err)
 				t.Encoding = CHARSET_BINARY
-			} else {
-				data = newdata
+			} else if n := countEscaped(segments); len(encoding) == 0 && n*100 > len(data)*escapeMaxPercent {
+				//too many undecodable bytes, it is not a text
+				t.Encoding = CHARSET_BINARY
 			}
-
 		}
 
 		if t.Encoding != CHARSET_BINARY {
-			t.Language = t.DetectLanguage(data)
-			return string(data), nil
+			text := textOfSegments(segments)
+			t.LineEnding, t.mixedLineEndings = DetectLineEnding(text)
+			if le := t.editorconfig.LineEnding(); len(le) > 0 {
+				t.LineEnding = le
+			}
+
+			t.Language = t.DetectLanguage(text)
+
+			var decoded string
+			decoded, t.escapes = joinSegments(segments)
+			return decoded, nil
 		}
 
 		if issetLanguage("hex") {
 			t.Language = "hex"
 		}
 
-		return bytetohex(bytes.NewReader(data)), nil
+		t.BOM = false
+		return bytetohex(bytes.NewReader(raw)), nil
 	}
 	return "", nil
 }
 
+const LINE_ENDING_LF = "LF"
+const LINE_ENDING_CRLF = "CRLF"
+const LINE_ENDING_CR = "CR"
+
+// DetectLineEnding returns the most used line ending and true if file has different line endings
+func DetectLineEnding(data []byte) (string, bool) {
+	crlf := bytes.Count(data, []byte("\r\n"))
+	cr := bytes.Count(data, []byte("\r")) - crlf
+	lf := bytes.Count(data, []byte("\n")) - crlf
+
+	var kinds int
+	for _, n := range []int{crlf, cr, lf} {
+		if n > 0 {
+			kinds++
+		}
+	}
+
+	switch {
+	case crlf > lf && crlf >= cr:
+		return LINE_ENDING_CRLF, kinds > 1
+	case cr > lf && cr > crlf:
+		return LINE_ENDING_CR, kinds > 1
+	}
+	return LINE_ENDING_LF, kinds > 1
+}
+
+// NormalizeLineEndings replace CRLF and CR by LF, text in the buffer always uses LF
+func NormalizeLineEndings(data []byte) []byte {
+	if bytes.IndexByte(data, '\r') < 0 {
+		return data
+	}
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+}
+
+// ConvertLineEndings replace LF by the line ending
+func ConvertLineEndings(data []byte, le string) []byte {
+	switch le {
+	case LINE_ENDING_CRLF:
+		return bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+	case LINE_ENDING_CR:
+		return bytes.Replace(data, []byte("\n"), []byte("\r"), -1)
+	}
+	return data
+}
+
+func (t *Tab) WarnMixedLineEndings() {
+	if !t.mixedLineEndings {
+		return
+	}
+
+	message := fmt.Sprintf("File has mixed line endings, all of them will be saved as %s.", t.LineEnding)
+	t.ShowInfoBar("lineending", gtk.MESSAGE_WARNING, message, []string{"OK"}, nil)
+}
+
+func (t *Tab) ToggleBOM(bom bool) {
+	if t == nil || t.BOM == bom || bomFor(t.Encoding) == nil {
+		return
+	}
+
+	t.BOM = bom
+	t.sourcebuffer.SetModified(true)
+}
+
+func (t *Tab) ChangeLineEnding(le string) {
+	if t == nil || t.LineEnding == le {
+		return
+	}
+
+	t.LineEnding = le
+	t.mixedLineEndings = false
+	t.HideInfoBar("lineending")
+
+	t.sourcebuffer.SetModified(true)
+}
+
 const CHARSET_BINARY = "binary"
 const CHARSET_UTF8 = "utf-8"
 const CHARSET_ASCII = "ascii"
 
 func (t *Tab) DetectEncoding(data []byte) (string, error) {
+	//known binary formats are recognized by signatures before zero bytes are taken for UTF-16
 	httpContentType := http.DetectContentType(data)
+	if !strings.HasPrefix(httpContentType, "text") && httpContentType != "application/octet-stream" {
+		return CHARSET_BINARY, nil
+	}
+
+	if charset := detectUTF16(data); len(charset) > 0 {
+		return charset, nil
+	}
+
 	if !strings.HasPrefix(httpContentType, "text") {
 		return CHARSET_BINARY, nil
 	}
@@ -308,47 +626,43 @@ func (t *Tab) DetectEncoding(data []byte) (string, error) {
 	}
 
 	if charset[1] == CHARSET_UTF8 && !utf8.Valid(data) {
-		return t.DetectChardet(data)
+		//a few invalid bytes in utf-8 text are escaped, if chardet is not sure in other charset
+		c, err := t.DetectChardet(data)
+		if (err != nil || len(t.encodingCandidates) > 0) && mostlyUTF8(data) {
+			return CHARSET_UTF8, nil
+		}
+		return c, err
 	}
 
 	return charset[1], nil
 }
 
+// DetectChardet returns the most probable charset, if detection is uncertain ranked candidates
+// are kept in the tab to offer them to the user
 func (t *Tab) DetectChardet(data []byte) (string, error) {
-	// log.Println(chardet.NewTextDetector().DetectAll(data))
-	r, err := chardet.NewTextDetector().DetectBest(data)
-	if err != nil || r.Confidence < 30 {
+	all, err := chardet.NewTextDetector().DetectAll(data)
+	if err != nil {
 		return "", errors.New("failed detect charset with chardet")
 	}
-	return r.Charset, nil
-}
 
-func (t *Tab) ChangeEncoding(data []byte, to, from string) ([]byte, error) {
-	converter, err := iconv.NewConverter(from, to)
-	if err != nil {
-		return nil, fmt.Errorf("unknown charsets: `%s` `%s`, %s", to, from, err)
+	if all[0].Confidence < chardetCertain && len(all) > 1 {
+		t.encodingCandidates = all
 	}
 
-	newdata := make([]byte, len(data)*4)
-	_, n, err := converter.Convert(data, newdata)
-	if err != nil {
-		return nil, fmt.Errorf("failed change encoding from `%s`, %s", from, err)
+	if all[0].Confidence < chardetMinimum {
+		return "", errors.New("failed detect charset with chardet")
 	}
+	return all[0].Charset, nil
+}
 
-	return newdata[:n], nil
-
-	// cd, err := iconv.Open(to, from)
-	// if err != nil {
-	// 	return nil, fmt.Errorf("unknown charsets: `%s` `%s`, %s", to, from, err)
-	// }
-	// defer cd.Close()
+func (t *Tab) ChangeEncoding(data []byte, to, from string) ([]byte, error) {
+	converter, err := NewConverter(from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer converter.Close()
 
-	// var outbuf = make([]byte, len(data))
-	// out, _, err := cd.Conv(data, outbuf)
-	// if err != nil {
-	// 	return nil, fmt.Errorf("failed change encoding from `%s`, %s", from, err)
-	// }
-	// return out, nil
+	return converter.ConvertBytes(data)
 }
 
 func (t *Tab) ChangeCurrEncoding(from string) {
@@ -362,16 +676,19 @@ func (t *Tab) ChangeCurrEncoding(from string) {
 		return
 	}
 
+	if t.large != nil {
+		errorMessage(errors.New("encoding can not be changed in the large file mode"))
+		return
+	}
+
 	var data []byte
 	var err error
 
-	dirtyState := t.Dirty
-
 	var tmpdata []byte
-	if t.Dirty || t.File == nil {
+	if t.Dirty || (t.File == nil && !isURI(t.Filename)) {
 		tmpdata = []byte(t.GetText(true))
 	} else {
-		tmpdata, err = ioutil.ReadFile(t.Filename)
+		tmpdata, err = t.readData(t.Filename)
 		if err != nil {
 			errorMessage(err)
 			log.Println(err)
@@ -384,34 +701,54 @@ func (t *Tab) ChangeCurrEncoding(from string) {
 			tmpdata = regexp.MustCompile("[ \n\r]+").ReplaceAll(tmpdata, []byte{})
 			data, err = hex.DecodeString(string(tmpdata))
 		} else {
-			data, err = t.ChangeEncoding(tmpdata, t.Encoding, CHARSET_UTF8)
+			data, err = t.EncodeText()
 		}
 		if err != nil {
 			errorMessage(err)
 			log.Println(err)
 			return
 		}
+	} else if from != CHARSET_BINARY {
+		//byte order mark of other charset is a part of the text
+		var bomCharset string
+		data, bomCharset = stripBOM(tmpdata)
+		from, t.BOM = bomEncoding(from, bomCharset)
+		if !t.BOM {
+			data = tmpdata
+		}
 	} else {
 		data = tmpdata
 	}
 
+	if bomFor(from) == nil {
+		t.BOM = false
+	}
+
+	var escapes []int
 	if from == CHARSET_BINARY {
 		t.Language = CHARSET_BINARY
 		data = []byte(bytetohex(bytes.NewReader(data)))
 	} else {
-		data, err = t.ChangeEncoding(data, CHARSET_UTF8, from)
+		segments, err := decodeEscaped(data, from)
 		if err != nil {
 			log.Println(err)
 			errorMessage(err)
 			return
 		}
+		var text string
+		text, escapes = joinSegments(segments)
+		data = []byte(text)
 	}
 
 	t.Encoding = from
 	if t.sourcebuffer != nil {
+		//text decoded from the file is not a modification
+		modified := t.sourcebuffer.GetModified()
 		t.sourcebuffer.SetText(string(data))
+		t.sourcebuffer.SetModified(modified)
+		t.escapes = escapes
+		t.tagEscapes()
 	}
-	t.Dirty = dirtyState
 }
 
 func (t *Tab) ChangeLanguage(lang string) {
@@ -434,7 +771,13 @@ func (t *Tab) DetectLanguage(data []byte) string {
 		return ""
 	}
 
-	ext := path.Ext(t.Filename)
+	if lang := ui.metadata.Language(t.Filename); issetLanguage(lang) {
+		return lang
+	}
+
+	filename := trimCompressionExt(t.Filename)
+
+	ext := path.Ext(filename)
 	if len(ext) > 0 {
 		ext = ext[1:]
 	}
@@ -442,7 +785,7 @@ func (t *Tab) DetectLanguage(data []byte) string {
 		return ext
 	}
 
-	if strings.HasSuffix(t.Filename, "rc") {
+	if strings.HasSuffix(filename, "rc") {
 		return "sh"
 	}
 
@@ -466,7 +809,7 @@ func (t *Tab) DetectLanguage(data []byte) string {
 		return maybexml
 	}
 
-	name := gsv.NewSourceLanguageManager().GuessLanguage(t.Filename, "").GetName()
+	name := gsv.NewSourceLanguageManager().GuessLanguage(filename, "").GetName()
 	if len(name) > 0 {
 		return strings.ToLower(name)
 	}
@@ -539,13 +882,39 @@ func (t *Tab) DnDHandler(ctx *glib.CallbackContext) {
 
 func (t *Tab) onchange() {
 	// t.Data = t.GetText()
-	t.Dirty = true
-	t.SetTabFGColor(conf.Tabs.FGModified)
+	t.swapChanged = true
 
 	t.Find()
 	// t.Empty = false
 }
 
+// onModifiedChanged update dirty state, modified flag of the buffer is cleared by undo and redo
+// to the save point, in the large file mode edits of other pages are also modifications
+func (t *Tab) onModifiedChanged() {
+	t.Dirty = t.sourcebuffer.GetModified()
+	if t.large != nil && len(t.large.edits) > 0 {
+		t.Dirty = true
+	}
+	t.updateTabColor()
+}
+
+// SetSavePoint mark the current text as saved
+func (t *Tab) SetSavePoint() {
+	t.sourcebuffer.SetModified(false)
+	t.onModifiedChanged()
+}
+
+func (t *Tab) updateTabColor() {
+	switch {
+	case t.Dirty:
+		t.SetTabFGColor(conf.Tabs.FGModified)
+	case len(t.Filename) == 0:
+		t.SetTabFGColor(conf.Tabs.FGNew)
+	default:
+		t.SetTabFGColor(conf.Tabs.FGNormal)
+	}
+}
+
 func (t *Tab) SetTabFGColor(col []int) {
 	color := convertColor(col)
 	t.label.ModifyFG(gtk.STATE_NORMAL, color)
@@ -554,50 +923,174 @@ func (t *Tab) SetTabFGColor(col []int) {
 	t.label.ModifyFG(gtk.STATE_ACTIVE, color)
 }
 
-func (t *Tab) Save() {
+func (t *Tab) Save() bool {
+	if t.ReadOnly {
+		err := fmt.Errorf("file %s is read only", t.Filename)
+		errorMessage(err)
+		log.Println(err)
+		return false
+	}
+
+	//buffer is not changed if saving is canceled
+	if !t.CheckEncodable() || !t.CheckEscapes() {
+		return false
+	}
+
+	t.applyEditorConfigOnSave()
+
 	var err error
 	var data []byte
-	if t.Encoding == CHARSET_BINARY {
+	if t.large != nil {
 
-		data, err = hextobyte(t.GetText(false))
-		if err != nil {
-			err := fmt.Errorf("failed decode hex, %s", err)
+		if !t.ConfirmLargeChanged() {
+			return false
+		}
+
+		if err := t.SaveLarge(); err != nil {
+			err := fmt.Errorf("failed save file `%s`, %s", t.Filename, err)
 			errorMessage(err)
 			log.Println(err)
-			return
+			return false
 		}
 
-	} else if t.ReadOnly {
-
-		err := fmt.Errorf("file %s is read only", t.Filename)
-		errorMessage(err)
-		log.Println(err)
-		return
+		t.SetSavePoint()
+		t.UpdateDiskState("")
+		return true
 
-	} else if t.Encoding == CHARSET_ASCII || t.Encoding == CHARSET_UTF8 {
+	} else if t.Encoding == CHARSET_BINARY {
 
-		data = []byte(t.GetText(true))
+		data, err = hextobyte(t.GetText(false))
+		if err != nil {
+			err := fmt.Errorf("failed decode hex, %s", err)
+			errorMessage(err)
+			log.Println(err)
+			return false
+		}
 
 	} else {
 
-		data, err = t.ChangeEncoding([]byte(t.GetText(true)), t.Encoding, "utf-8")
+		data, err = t.EncodeText()
 		if err != nil {
 			err := fmt.Errorf("failed restore encoding, save failed, %s", err)
 			errorMessage(err)
 			log.Println(err)
-			return
+			return false
 		}
 
 	}
 
-	if err := ioutil.WriteFile(t.Filename, data, 0644); err != nil {
+	if t.BOM && t.Encoding != CHARSET_BINARY {
+		data = append(bomFor(t.Encoding), data...)
+	}
+
+	if len(t.Compression) > 0 {
+		t.warnCompressionLevel()
+		data, err = compress(data, t.Compression, t.compressLevel)
+		if err != nil {
+			err := fmt.Errorf("failed save file `%s`, %s", t.Filename, err)
+			errorMessage(err)
+			log.Println(err)
+			return false
+		}
+	}
+
+	if isURI(t.Filename) {
+		err = gioWriteURI(t.Filename, data)
+	} else {
+		err = saveFile(t.Filename, data)
+	}
+	if err != nil {
 		err := fmt.Errorf("failed save file `%s`, %s", t.Filename, err)
 		errorMessage(err)
 		log.Println(err)
-		return
+		return false
+	}
+
+	t.UpdateDiskState(t.GetText(true))
+	t.SetSavePoint()
+	t.WriteSwap()
+	return true
+}
+
+// SetReadOnly forbid or allow editing of the text, read only tab is marked by the lock
+func (t *Tab) SetReadOnly(readonly bool) {
+	t.ReadOnly = readonly
+
+	editable := !readonly
+	if t.large != nil {
+		editable = editable && t.large.editable
+	}
+	t.sourceview.SetEditable(editable)
+
+	t.lock.SetVisible(readonly)
+}
+
+// isWritable check that the file can be written, it catches permissions, read only mounts
+// and gvfs backends which reject writes, the file itself is not opened
+func isWritable(filename string) bool {
+	if isURI(filename) {
+		return gioCanWrite(filename)
+	}
+
+	return canWriteFile(filename)
+}
+
+func (t *Tab) SetFilename(filename string) {
+	t.RemoveSwap()
+	t.Filename = filename
+	t.label.SetText(path.Base(filename))
+	t.label.SetTooltipText(filename)
+
+	t.editorconfig = LoadEditorConfig(filename)
+	if t.Encoding != CHARSET_BINARY {
+		t.applyEditorConfig()
 	}
+}
 
-	t.SetTabFGColor(conf.Tabs.FGNormal)
+// ShowInfoBar show not modal message above the text, f called with index of pressed button,
+// message with the same key replaces previous one
+func (t *Tab) ShowInfoBar(key string, mtype gtk.MessageType, message string, buttons []string, f func(int)) {
+	label := gtk.NewLabel(message)
+	label.SetLineWrap(true)
+	t.ShowInfoBarWidget(key, mtype, label, buttons, f)
+}
+
+// ShowInfoBarWidget the same as ShowInfoBar, but content of the bar is the widget
+func (t *Tab) ShowInfoBarWidget(key string, mtype gtk.MessageType, widget gtk.IWidget, buttons []string, f func(int)) {
+	t.HideInfoBar(key)
+
+	bar := gtk.NewInfoBar()
+	bar.SetMessageType(mtype)
+
+	content := gtk.Container{Widget: *bar.GetContentArea()}
+	content.Add(widget)
+
+	for i, b := range buttons {
+		bar.AddButton(b, gtk.ResponseType(i))
+	}
+
+	bar.Connect("response", func(ctx *glib.CallbackContext) {
+		n := int(ctx.Args(0))
+		t.HideInfoBar(key)
+		if f != nil && n >= 0 && n < len(buttons) {
+			f(n)
+		}
+	})
+
+	if t.infobars == nil {
+		t.infobars = make(map[string]*gtk.InfoBar)
+	}
+	t.infobars[key] = bar
+
+	t.page.PackStart(bar, false, false, 0)
+	bar.ShowAll()
+}
+
+func (t *Tab) HideInfoBar(key string) {
+	if bar, ok := t.infobars[key]; ok {
+		bar.Destroy()
+		delete(t.infobars, key)
+	}
 }
 
 func (t *Tab) GetText(hiddenChars bool) string {
@@ -701,6 +1194,10 @@ func (t *Tab) onMoveCursor() {
 
 	t.Highlight(t.findindexCurrent, false)
 	t.findindexCurrent = -1
+
+	if ui.notebook.PageNum(t.page) == ui.notebook.GetCurrentPage() {
+		ui.inspector.Update(t)
+	}
 }
 
 func (t *Tab) FindNext(next bool) {
@@ -0,0 +1,41 @@
+package main
+
+/*
+#cgo pkg-config: gtk+-2.0
+#include <gtk/gtk.h>
+*/
+import "C"
+
+import (
+	"github.com/mattn/go-gtk/gtk"
+	gsv "github.com/mattn/go-gtk/gtksourceview"
+)
+
+// beginUserAction group following changes of the buffer to one undo step,
+// gtk_text_buffer_begin_user_action is not bound by go-gtk
+func beginUserAction(buffer *gsv.SourceBuffer) {
+	C.gtk_text_buffer_begin_user_action((*C.GtkTextBuffer)(buffer.GObject.Object))
+}
+
+func endUserAction(buffer *gsv.SourceBuffer) {
+	C.gtk_text_buffer_end_user_action((*C.GtkTextBuffer)(buffer.GObject.Object))
+}
+
+// tagRanges returns character offsets of ranges where the tag is applied
+func tagRanges(buffer *gsv.SourceBuffer, tag *gtk.TextTag) [][2]int {
+	ctag := (*C.GtkTextTag)(tag.GObject.Object)
+
+	var iter C.GtkTextIter
+	C.gtk_text_buffer_get_start_iter((*C.GtkTextBuffer)(buffer.GObject.Object), &iter)
+
+	var ranges [][2]int
+	for {
+		if C.gtk_text_iter_begins_tag(&iter, ctag) == C.FALSE && C.gtk_text_iter_forward_to_tag_toggle(&iter, ctag) == C.FALSE {
+			break
+		}
+		start := int(C.gtk_text_iter_get_offset(&iter))
+		C.gtk_text_iter_forward_to_tag_toggle(&iter, ctag)
+		ranges = append(ranges, [2]int{start, int(C.gtk_text_iter_get_offset(&iter))})
+	}
+	return ranges
+}
@@ -3,7 +3,8 @@ package main
 import (
 	"fmt"
 	"log"
-	"path"
+	"os"
+	"sort"
 	"strconv"
 	"unsafe"
 
@@ -17,17 +18,35 @@ type UI struct {
 	vbox   *gtk.VBox
 
 	menu     *Menu
+	paned    *gtk.HPaned
+	filetree *FileTree
 	notebook *gtk.Notebook
 	tabs     []*Tab
 	footer   *Footer
 
-	NoActivate bool
-	encodings  map[string]*gtk.RadioAction
-	languages  map[string]*gtk.RadioAction
+	session      string
+	sessionLocks map[string]*os.File
+	recent       *Recent
+	metadata     *Metadata
+
+	NoActivate     bool
+	encodings      map[string]*gtk.RadioAction
+	encodingsGroup *glib.SList
+	bom            *gtk.ToggleAction
+	readOnly       *gtk.ToggleAction
+	lineEndings    map[string]*gtk.RadioAction
+	languages      map[string]*gtk.RadioAction
+
+	fileTreeAction *gtk.ToggleAction
+
+	inspector       *Inspector
+	inspectorAction *gtk.ToggleAction
 }
 
 func CreateUI() *UI {
 	ui := new(UI)
+	ui.session = defaultSession
+	ui.sessionLocks = make(map[string]*os.File)
 	ui.window = gtk.NewWindow(gtk.WINDOW_TOPLEVEL)
 	ui.window.SetDefaultSize(600, 300)
 	ui.window.SetSizeRequest(100, 100)
@@ -35,6 +54,7 @@ func CreateUI() *UI {
 
 	ui.menu = NewMenu(ui.window)
 	ui.footer = NewFooter(ui.menu.accelGroup)
+	ui.inspector = NewInspector()
 	ui.SetActions()
 
 	ui.vbox = gtk.NewVBox(false, 0)
@@ -43,16 +63,29 @@ func CreateUI() *UI {
 	ui.notebook = gtk.NewNotebook()
 	ui.notebook.Connect("switch-page", ui.onSwitchPage)
 	ui.notebook.Connect("page-reordered", ui.onPageReordered)
-	ui.vbox.PackStart(ui.notebook, true, true, 0)
+
+	ui.filetree = NewFileTree()
+
+	ui.paned = gtk.NewHPaned()
+	ui.paned.Pack1(ui.filetree.box, false, false)
+	ui.paned.Pack2(ui.notebook, true, false)
+	ui.paned.SetPosition(200)
+	ui.vbox.PackStart(ui.paned, true, true, 0)
+	ui.vbox.PackStart(ui.inspector.box, false, false, 0)
 
 	ui.vbox.PackStart(ui.footer.table, false, false, 0)
 	ui.window.Add(ui.vbox)
 
-	ui.window.Connect("destroy", ui.Quit)
+	ui.window.Connect("delete-event", ui.onDelete)
+	ui.window.Connect("destroy", ui.exit)
+
+	ui.AutosaveSwaps()
 
 	ui.window.ShowAll()
 
 	ui.footer.table.SetVisible(false)
+	ui.filetree.box.SetVisible(false)
+	ui.inspector.box.SetVisible(false)
 	ui.menu.menubar.SetVisible(conf.UI.MenuBarVisible)
 
 	return ui
@@ -76,15 +109,33 @@ func NewMenu(w *gtk.Window) *Menu {
 			<menuitem action='Open' />
 			<menuitem action='Save' />
 			<menuitem action='SaveAs' />
+			<menuitem action='SaveAll' />
+			<menuitem action='Revert' />
+			<menu action='ReopenEncoding'>
+			` + xmlReopenEncodings() + `
+			</menu>
+			<menu action='Recent'>
+			</menu>
+			<separator />
+			<menuitem action='SaveSession' />
+			<menuitem action='LoadSession' />
 			<separator />
 			<menu action='Encoding'>
 			` + xmlEncodings() + `
+			<separator />
+			<menuitem action='BOM' />
+			</menu>
+			<menu action='LineEndings'>
+			` + xmlLineEndings() + `
 			</menu>
 			<menu action='Language'>
 			` + xmlLanguages() + `
 			</menu>
 			<separator />
 			<menuitem action='CloseTab' />
+			<menuitem action='CloseOthers' />
+			<menuitem action='CloseRight' />
+			<menuitem action='CloseAll' />
 			<menuitem action='Quit' />
 		</menu>
 
@@ -97,11 +148,16 @@ func NewMenu(w *gtk.Window) *Menu {
 			<menuitem action='ReplaceOne'/>
 			<menuitem action='ReplaceAll'/>
 			<separator />
+			<menuitem action='InsertCharacter'/>
+			<separator />
 			<menuitem action='Preferences'/>
 		</menu>
 
 		<menu name='View' action='View'>
 			<menuitem action='Menubar'/>
+			<menuitem action='ReadOnly'/>
+			<menuitem action='FileTree'/>
+			<menuitem action='Inspector'/>
 		</menu>
 
 	</menubar>
@@ -139,19 +195,44 @@ func (ui *UI) SetActions() {
 	ui.newActionStock("Open", gtk.STOCK_OPEN, "", ui.Open)
 	ui.newActionStock("Save", gtk.STOCK_SAVE, "", ui.Save)
 	ui.newActionStock("SaveAs", gtk.STOCK_SAVE_AS, "<control><shift>s", ui.SaveAs)
+	ui.newAction("SaveAll", "Save All", "<control><alt>s", ui.SaveAll)
+	ui.newActionStock("Revert", gtk.STOCK_REVERT_TO_SAVED, "", ui.RevertCurrentTab, "")
+	ui.newAction("ReopenEncoding", "Reopen with Encoding", "", nil)
+	for _, c := range charsets() {
+		if len(c) != 0 {
+			ui.newAction("reopen-"+c, c, "", ui.RevertCurrentTab, c)
+		}
+	}
+	ui.newAction("ReopenOther", "Other Encodings...", "", ui.ReopenOtherCurrentTab)
+	ui.newAction("Recent", "Recent", "", nil)
+	ui.newAction("SaveSession", "Save Session...", "", ui.SaveSessionAs)
+	ui.newAction("LoadSession", "Load Session...", "", ui.OpenSession)
 
 	//Encodings
 	ui.newAction("Encoding", "Encoding", "", nil)
 	ui.encodings = make(map[string]*gtk.RadioAction)
-	var encodingsGroup *glib.SList
-	for n, c := range charsets {
+	for n, c := range charsets() {
 		if len(c) != 0 {
 			ra := ui.newRadioAction(c, c, "", false, n, ui.changeEncodingCurrentTab, c)
-			ra.SetGroup(encodingsGroup)
-			encodingsGroup = ra.GetGroup()
+			ra.SetGroup(ui.encodingsGroup)
+			ui.encodingsGroup = ra.GetGroup()
 			ui.encodings[c] = ra
 		}
 	}
+	ui.newAction("ChooseEncoding", "Other Encodings...", "", ui.ChooseEncodingCurrentTab)
+
+	ui.bom = ui.newToggleAction("BOM", "Byte Order Mark", "", false, ui.toggleBOMCurrentTab)
+
+	//Line endings
+	ui.newAction("LineEndings", "Line Endings", "", nil)
+	ui.lineEndings = make(map[string]*gtk.RadioAction)
+	var lineEndingsGroup *glib.SList
+	for n, le := range lineEndings {
+		ra := ui.newRadioAction(le, le, "", false, n, ui.changeLineEndingCurrentTab, le)
+		ra.SetGroup(lineEndingsGroup)
+		lineEndingsGroup = ra.GetGroup()
+		ui.lineEndings[le] = ra
+	}
 
 	//Languages
 	ui.newAction("Language", "Language", "", nil)
@@ -168,6 +249,9 @@ func (ui *UI) SetActions() {
 	}
 
 	ui.newAction("CloseTab", "Close Tab", "<control>w", ui.CloseCurrentTab)
+	ui.newAction("CloseOthers", "Close Other Tabs", "", func() { ui.CloseOthers(ui.GetCurrentTab()) })
+	ui.newAction("CloseRight", "Close Tabs to the Right", "", func() { ui.CloseRight(ui.GetCurrentTab()) })
+	ui.newAction("CloseAll", "Close All Tabs", "<control><shift>w", ui.CloseAll)
 	ui.newActionStock("Quit", gtk.STOCK_QUIT, "", ui.Quit)
 
 	// Edit
@@ -178,10 +262,14 @@ func (ui *UI) SetActions() {
 	ui.newActionStock("Replace", gtk.STOCK_FIND_AND_REPLACE, "<control>h", ui.footer.ShowReplbar)
 	ui.newAction("ReplaceOne", "Replace One", "<control><shift>h", ui.ReplaceOne)
 	ui.newAction("ReplaceAll", "Replace All", "<control><alt>Return", ui.ReplaceAll)
+	ui.newAction("InsertCharacter", "Insert Character...", "", ui.InsertCharacter)
 	ui.newAction("Preferences", "Preferences", "<control><shift>p", conf.OpenWindow)
 
 	// View
 	ui.newToggleAction("Menubar", "Menubar", "<control>M", conf.UI.MenuBarVisible, ui.ToggleMenuBar)
+	ui.readOnly = ui.newToggleAction("ReadOnly", "Read Only", "", false, ui.toggleReadOnlyCurrentTab)
+	ui.fileTreeAction = ui.newToggleAction("FileTree", "File Tree", "F9", false, ui.ToggleFileTree)
+	ui.inspectorAction = ui.newToggleAction("Inspector", "Character Inspector", "F8", false, ui.ToggleInspector)
 
 	// Footer
 	ui.footer.regBtn.Connect("toggled", ui.Find)
@@ -209,11 +297,12 @@ func (ui *UI) newActionStock(dst, stock, accel string, f interface{}, vars ...in
 	ui.menu.actionGroup.AddActionWithAccel(action, accel)
 }
 
-func (ui *UI) newToggleAction(dst, label, accel string, state bool, f func()) {
+func (ui *UI) newToggleAction(dst, label, accel string, state bool, f func()) *gtk.ToggleAction {
 	action := gtk.NewToggleAction(dst, label, "", "")
 	action.SetActive(state)
 	action.Connect("activate", f)
 	ui.menu.actionGroup.AddActionWithAccel(&action.Action, accel)
+	return action
 }
 
 func (ui *UI) newRadioAction(dst, label, accel string, state bool, n int, f interface{}, vars ...interface{}) *gtk.RadioAction {
@@ -224,31 +313,40 @@ func (ui *UI) newRadioAction(dst, label, accel string, state bool, n int, f inte
 	return action
 }
 
-func (ui *UI) NewTab(filename string) {
+func (ui *UI) NewTab(filename string) *Tab {
+	if stat, err := os.Stat(filename); err == nil && stat.IsDir() {
+		ui.OpenDir(filename)
+		return nil
+	}
+
 	t := NewTab(filename)
 	if t == nil {
-		return
+		return nil
 	}
 
-	n := ui.notebook.AppendPage(t.swin, t.eventbox)
+	n := ui.notebook.AppendPage(t.page, t.eventbox)
 	ui.notebook.ShowAll()
 	ui.notebook.SetCurrentPage(n)
 
-	ui.notebook.ChildSet(t.swin, "tab-expand", conf.Tabs.Homogeneous)
-	ui.notebook.SetReorderable(t.swin, true)
+	ui.notebook.ChildSet(t.page, "tab-expand", conf.Tabs.Homogeneous)
+	ui.notebook.SetReorderable(t.page, true)
 
 	t.sourceview.GrabFocus()
 	t.UpdateMenuSeleted()
 
 	ui.tabs = append(ui.tabs, t)
+
+	ui.recent.Add(t.Filename)
+
+	return t
 }
 
 func (ui *UI) ShowTab(t *Tab) {
 	log.Println("ShowTab", t.Filename)
 	for _, uitab := range ui.tabs {
-		uitab.swin.Hide()
+		uitab.page.Hide()
 	}
-	t.swin.ShowAll()
+	t.page.ShowAll()
 }
 
 func (ui *UI) TabsUpdateConf() {
@@ -259,26 +357,38 @@ func (ui *UI) TabsUpdateConf() {
 
 func (ui *UI) Open() {
 	dialog := gtk.NewFileChooserDialog("Open File", ui.window, gtk.FILE_CHOOSER_ACTION_OPEN, gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL, gtk.STOCK_OPEN, gtk.RESPONSE_ACCEPT)
+	dialog.SetLocalOnly(false)
 
 	if dialog.Run() == gtk.RESPONSE_ACCEPT {
-		ui.NewTab(dialog.GetFilename())
+		ui.NewTab(chooserFilename(dialog))
 	}
 	dialog.Destroy()
 }
 func (ui *UI) Save() {
-	t := ui.GetCurrentTab()
+	ui.SaveTab(ui.GetCurrentTab())
+}
+
+// SaveTab save tab, for new and read only tabs filename asked with the save dialog
+func (ui *UI) SaveTab(t *Tab) bool {
+	if len(t.Filename) == 0 || t.ReadOnly {
+		if n := ui.notebook.PageNum(t.page); n >= 0 {
+			ui.notebook.SetCurrentPage(n)
+		}
 
-	if len(t.Filename) == 0 {
 		filename := dialogSave()
 		if len(filename) == 0 {
-			return
+			return false
 		}
 
-		t.Filename = filename
-		t.label.SetText(path.Base(filename))
-		t.label.SetTooltipText(filename)
+		t.SetFilename(filename)
+		t.SetReadOnly(false)
+	}
+
+	if !t.Save() {
+		return false
 	}
-	t.Save()
+	ui.recent.Add(t.Filename)
+	return true
 }
 func (ui *UI) SaveAs() {
 	t := ui.GetCurrentTab()
@@ -288,19 +398,114 @@ func (ui *UI) SaveAs() {
 		return
 	}
 
-	t.Filename = filename
-	t.label.SetText(path.Base(filename))
-	t.label.SetTooltipText(filename)
-	t.Save()
+	t.SetFilename(filename)
+	t.SetReadOnly(false)
+	if t.Save() {
+		ui.recent.Add(t.Filename)
+	}
 }
 
 func (ui *UI) Quit() {
+	if !ui.ConfirmClose(ui.fileTabs()) {
+		return
+	}
+	ui.exit()
+}
+
+func (ui *UI) onDelete() bool {
+	return !ui.ConfirmClose(ui.fileTabs())
+}
+
+// fileTabs returns tabs with files and standard input, text of other new tabs is kept by session
+// unless the session is used by another instance
+func (ui *UI) fileTabs() []*Tab {
+	kept := ui.lockSession(ui.session)
+
+	var tabs []*Tab
+	for _, t := range ui.tabs {
+		if len(t.Filename) > 0 || t.isStdin() || !kept {
+			tabs = append(tabs, t)
+		}
+	}
+	return tabs
+}
+
+func (ui *UI) exit() {
+	if ui.session != defaultSession {
+		if err := ui.SaveSession(ui.session); err != nil {
+			log.Println(err)
+		}
+	}
+	if ui.lockSession(defaultSession) {
+		if err := ui.SaveSession(defaultSession); err != nil {
+			log.Println(err)
+		}
+	}
+
 	for _, t := range ui.tabs {
-		t.File.Close()
+		t.Close()
 	}
 	gtk.MainQuit()
 }
 
+// ConfirmClose ask what to do with unsaved tabs, returns false if closing is canceled
+func (ui *UI) ConfirmClose(tabs []*Tab) bool {
+	var dirty []*Tab
+	for _, t := range tabs {
+		if t.Dirty {
+			dirty = append(dirty, t)
+		}
+	}
+
+	if len(dirty) == 0 {
+		return true
+	}
+
+	dialog := gtk.NewDialog()
+	dialog.SetTitle("Unsaved changes")
+	dialog.SetTransientFor(ui.window)
+	dialog.SetModal(true)
+
+	vbox := dialog.GetVBox()
+	label := gtk.NewLabel("The following files have unsaved changes:")
+	label.SetAlignment(0, 0.5)
+	vbox.PackStart(label, false, false, 5)
+
+	for _, t := range dirty {
+		name := t.label.GetText()
+		if len(t.Filename) > 0 {
+			name = t.Filename
+		}
+
+		l := gtk.NewLabel(name)
+		l.SetAlignment(0, 0.5)
+		vbox.PackStart(l, false, false, 0)
+	}
+
+	dialog.AddButton(gtk.STOCK_DISCARD, gtk.RESPONSE_NO)
+	dialog.AddButton(gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL)
+	dialog.AddButton(gtk.STOCK_SAVE, gtk.RESPONSE_YES)
+	dialog.SetDefaultResponse(gtk.RESPONSE_YES)
+	dialog.ShowAll()
+
+	response := dialog.Run()
+	dialog.Destroy()
+
+	switch response {
+	case gtk.RESPONSE_NO:
+		return true
+	case gtk.RESPONSE_YES:
+		for _, t := range dirty {
+			if !ui.SaveTab(t) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
 func (ui *UI) Find() {
 	ui.GetCurrentTab().Find()
 }
@@ -329,12 +534,21 @@ func (ui *UI) ToggleStatusBar() {
 	// ui.menu.statusbar.SetActive(conf.UI.StatusBarVisible)
 }
 
+// chooserFilename returns local path of the chosen file, or URI for remote locations
+func chooserFilename(dialog *gtk.FileChooserDialog) string {
+	if filename := dialog.GetFilename(); len(filename) > 0 {
+		return filename
+	}
+	return dialog.GetUri()
+}
+
 func dialogSave() string {
 	dialog := gtk.NewFileChooserDialog("Save File", ui.window, gtk.FILE_CHOOSER_ACTION_SAVE, gtk.STOCK_CANCEL, gtk.RESPONSE_CANCEL, gtk.STOCK_SAVE, gtk.RESPONSE_ACCEPT)
+	dialog.SetLocalOnly(false)
 
 	var filename string
 	if dialog.Run() == gtk.RESPONSE_ACCEPT {
-		filename = dialog.GetFilename()
+		filename = chooserFilename(dialog)
 	}
 
 	dialog.Destroy()
@@ -346,8 +560,61 @@ func (ui *UI) changeEncodingCurrentTab(ctx *glib.CallbackContext) {
 	if ui.NoActivate {
 		return
 	}
-	charset := ctx.Data().(string)
-	ui.GetCurrentTab().ChangeCurrEncoding(charset)
+	ui.setEncodingCurrentTab(ctx.Data().(string))
+}
+
+// setEncodingCurrentTab decode the current tab from the charset, choice is remembered for the file
+func (ui *UI) setEncodingCurrentTab(charset string) {
+	t := ui.GetCurrentTab()
+	if t == nil {
+		return
+	}
+
+	//byte order mark may define other charset, ex: UTF-16LE for generic UTF-16
+	prev := t.Encoding
+	t.ChangeCurrEncoding(charset)
+	if t.Encoding == charset || t.Encoding != prev {
+		ui.metadata.SetEncoding(t.Filename, t.Encoding)
+	}
+	t.UpdateMenuSeleted()
+}
+
+func (ui *UI) toggleBOMCurrentTab() {
+	if ui.NoActivate {
+		return
+	}
+	ui.GetCurrentTab().ToggleBOM(ui.bom.GetActive())
+}
+
+// RevertCurrentTab read the file of the current tab again, encoding is passed as data of callback
+func (ui *UI) RevertCurrentTab(ctx *glib.CallbackContext) {
+	ui.reopenCurrentTab(ctx.Data().(string))
+}
+
+func (ui *UI) reopenCurrentTab(encoding string) {
+	t := ui.GetCurrentTab()
+	if t == nil {
+		return
+	}
+	t.Revert(encoding)
+	if len(encoding) > 0 && t.Encoding == encoding {
+		ui.metadata.SetEncoding(t.Filename, encoding)
+	}
+}
+
+func (ui *UI) toggleReadOnlyCurrentTab() {
+	if ui.NoActivate {
+		return
+	}
+	ui.GetCurrentTab().SetReadOnly(ui.readOnly.GetActive())
+}
+
+func (ui *UI) changeLineEndingCurrentTab(ctx *glib.CallbackContext) {
+	if ui.NoActivate {
+		return
+	}
+	le := ctx.Data().(string)
+	ui.GetCurrentTab().ChangeLineEnding(le)
 }
 
 func (ui *UI) changeLanguageCurrentTab(ctx *glib.CallbackContext) {
@@ -355,7 +622,11 @@ func (ui *UI) changeLanguageCurrentTab(ctx *glib.CallbackContext) {
 		return
 	}
 	lang := ctx.Data().(string)
-	ui.GetCurrentTab().ChangeLanguage(lang)
+	t := ui.GetCurrentTab()
+	t.ChangeLanguage(lang)
+	if t != nil {
+		ui.metadata.SetLanguage(t.Filename, lang)
+	}
 }
 
 func (ui *UI) LookupTab(filename string) (*Tab, int, bool) {
@@ -374,17 +645,85 @@ func (ui *UI) CloseCurrentTab() {
 }
 
 func (ui *UI) CloseTab(n int) {
-	t := ui.tabs[n]
+	ui.CloseTabs([]*Tab{ui.tabs[n]})
+}
 
-	ui.notebook.RemovePage(t.swin, n)
-	t.Close()
-	ui.tabs = append(ui.tabs[:n], ui.tabs[n+1:]...)
+// SaveAll save modified tabs, stops if saving of some tab is failed or canceled
+func (ui *UI) SaveAll() {
+	for _, t := range ui.orderedTabs() {
+		if t.Dirty && !ui.SaveTab(t) {
+			return
+		}
+	}
+}
+
+func (ui *UI) CloseAll() {
+	ui.CloseTabs(ui.orderedTabs())
+}
+
+// CloseOthers close all tabs except t
+func (ui *UI) CloseOthers(t *Tab) {
+	var tabs []*Tab
+	for _, tab := range ui.orderedTabs() {
+		if tab != t {
+			tabs = append(tabs, tab)
+		}
+	}
+	ui.CloseTabs(tabs)
+}
+
+// CloseRight close tabs placed after t in the notebook
+func (ui *UI) CloseRight(t *Tab) {
+	n := ui.notebook.PageNum(t.page)
+
+	var tabs []*Tab
+	for _, tab := range ui.orderedTabs() {
+		if ui.notebook.PageNum(tab.page) > n {
+			tabs = append(tabs, tab)
+		}
+	}
+	ui.CloseTabs(tabs)
+}
+
+// CloseTabs close several tabs with one confirmation, goatee quits if all tabs are closed
+func (ui *UI) CloseTabs(tabs []*Tab) {
+	if len(tabs) == 0 || !ui.ConfirmClose(tabs) {
+		return
+	}
+
+	for _, t := range tabs {
+		for i, tab := range ui.tabs {
+			if tab == t {
+				ui.removeTab(i)
+				break
+			}
+		}
+	}
 
 	if len(ui.tabs) == 0 {
-		gtk.MainQuit()
+		ui.exit()
 	}
 }
 
+// orderedTabs returns tabs in order of notebook pages
+func (ui *UI) orderedTabs() []*Tab {
+	tabs := make([]*Tab, len(ui.tabs))
+	copy(tabs, ui.tabs)
+	sort.SliceStable(tabs, func(i, j int) bool {
+		return ui.notebook.PageNum(tabs[i].page) < ui.notebook.PageNum(tabs[j].page)
+	})
+	return tabs
+}
+
+// removeTab remove the tab without confirmation, n is index in ui.tabs
+func (ui *UI) removeTab(n int) {
+	t := ui.tabs[n]
+
+	ui.notebook.RemovePage(t.page, ui.notebook.PageNum(t.page))
+	t.Close()
+	ui.tabs = append(ui.tabs[:n], ui.tabs[n+1:]...)
+}
+
 func (ui *UI) GetCurrentTab() *Tab {
 	if ui.notebook == nil {
 		return &Tab{}
@@ -402,6 +741,7 @@ func (ui *UI) onSwitchPage(ctx *glib.CallbackContext) {
 	n, _ := strconv.Atoi(fmt.Sprintf("%v", ctx.Args(1)))
 	if n < len(ui.tabs) {
 		ui.tabs[n].UpdateMenuSeleted()
+		ui.inspector.Update(ui.tabs[n])
 	}
 }
 
@@ -410,7 +750,7 @@ func (ui *UI) onPageReordered(ctx *glib.CallbackContext) {
 	i := int(ctx.Args(1))
 
 	for n, t := range ui.tabs {
-		if child.GWidget == t.swin.Container.Widget.GWidget {
+		if child.GWidget == t.page.Container.Widget.GWidget {
 			ui.tabs[n], ui.tabs[i] = ui.tabs[i], ui.tabs[n]
 			break
 		}